@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runAccounts runs the 'accounts' subcommands.
+func runAccounts(c clients, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect an accounts subcommand: 'list' or 'rotate'")
+	}
+
+	switch args[0] {
+	case "list":
+		return printAccounts(c)
+	case "rotate":
+		if len(args) < 2 {
+			return fmt.Errorf("expect a username: accounts rotate <user>")
+		}
+		for _, username := range args[1:] {
+			err := c.lemmy.RotatePassword(username)
+			if err != nil {
+				return fmt.Errorf("could not rotate password of %q: %w", username, err)
+			}
+			fmt.Printf("Rotated password of %s\n", username)
+		}
+		return nil
+	default:
+		return fmt.Errorf("no valid accounts subcommand %q. Expect 'list' or 'rotate'", args[0])
+	}
+}
+
+// printAccounts prints the accounts with a stored password. Accounts which
+// still use the legacy password are not listed until their next login.
+func printAccounts(c clients) error {
+	creds, err := c.lemmy.Accounts().List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCREATED\tROTATED\tCHANGE PENDING")
+	for _, cred := range creds {
+		rotated := "never"
+		if !cred.Rotated.IsZero() {
+			rotated = cred.Rotated.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", cred.Username, cred.Created.Format(time.RFC3339),
+			rotated, len(cred.PreviousPassword) > 0)
+	}
+	return w.Flush()
+}
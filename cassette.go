@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/store"
+	"os"
+	"path"
+	"time"
+)
+
+// A recording also keeps the db as it was at the start, and the random seed
+// and start time of the run, so a replay makes the same decisions offline.
+const (
+	cassetteDumpFile = "db.dump"
+	cassetteRunFile  = "run.json"
+)
+
+type cassetteRun struct {
+	Seed  int64     `json:"seed"`
+	Start time.Time `json:"start"`
+}
+
+// recordRun dumps the db into the cassette and seeds rand with a new seed,
+// which is saved with the start time. The dump has the sealed passwords of
+// the accounts, keep recordings of the production db private.
+func recordRun(db *store.Store, dir string) error {
+	dumpFile, err := os.Create(path.Join(dir, cassetteDumpFile))
+	if err != nil {
+		return fmt.Errorf("could not create db dump: %w", err)
+	}
+	defer dumpFile.Close()
+	_, err = db.Dump(dumpFile)
+	if err != nil {
+		return err
+	}
+
+	run := cassetteRun{
+		Seed:  time.Now().UnixNano(),
+		Start: clock.Now().UTC(),
+	}
+	runJSON, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal run: %w", err)
+	}
+	err = os.WriteFile(path.Join(dir, cassetteRunFile), runJSON, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write run: %w", err)
+	}
+	rand.Seed(run.Seed)
+	return nil
+}
+
+// replayRun restores the db of the cassette into memory, so a replay never
+// touches badger.db. The copy is migrated if it was recorded with an older
+// schema. Rand gets the seed of the recording and the clock stands at its
+// start.
+func replayRun(dir string) (*store.Store, error) {
+	runJSON, err := os.ReadFile(path.Join(dir, cassetteRunFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read run: %w", err)
+	}
+	run := cassetteRun{}
+	err = json.Unmarshal(runJSON, &run)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal run: %w", err)
+	}
+
+	dumpFile, err := os.Open(path.Join(dir, cassetteDumpFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not open db dump: %w", err)
+	}
+	defer dumpFile.Close()
+	db := store.OpenMemory()
+	_, err = db.Restore(dumpFile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Migrate()
+	if err != nil {
+		return nil, err
+	}
+
+	rand.Seed(run.Seed)
+	clock.Freeze(run.Start)
+	return db, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"newsbots/pkg/cassette"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/lemmytest"
+	"newsbots/pkg/report"
+	"newsbots/pkg/store"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var recordCassettes = flag.Bool("record", false, "record the cassettes in testdata against fake servers")
+
+// rssCassette is a recorded rss run of the test feed, posted by a random
+// account.
+const rssCassette = "testdata/cassettes/rss"
+
+// hostTransport sends the requests for the hosts to other addresses, with
+// the original Host header. The cassettes are recorded with fixed hosts, not
+// the random ports of httptest.
+type hostTransport struct {
+	addrs map[string]string
+	next  http.RoundTripper
+}
+
+func (h hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr, found := h.addrs[req.URL.Host]
+	if !found {
+		return nil, fmt.Errorf("unexpected host %s", req.URL.Host)
+	}
+	req = req.Clone(req.Context())
+	req.Host = req.URL.Host
+	req.URL.Host = addr
+	return h.next.RoundTrip(req)
+}
+
+// requestLog remembers the requests and the status of their responses.
+type requestLog struct {
+	next     http.RoundTripper
+	mutex    sync.Mutex
+	requests []string
+}
+
+func (l *requestLog) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := l.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = fmt.Sprint(resp.StatusCode)
+	}
+	l.mutex.Lock()
+	l.requests = append(l.requests, fmt.Sprintf("%s %s %s", req.Method, req.URL, status))
+	l.mutex.Unlock()
+	return resp, err
+}
+
+func (l *requestLog) count(request string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	n := 0
+	for _, r := range l.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func useTransport(t *testing.T, transport http.RoundTripper) {
+	previous := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() {
+		http.DefaultTransport = previous
+	})
+}
+
+// recordRSSCassette runs rss against the fake servers, like main does with
+// HTTP_CASSETTE=record:dir.
+func recordRSSCassette(t *testing.T, dir string) {
+	lemmy := lemmytest.New()
+	defer lemmy.Close()
+	lemmy.AddCommunity(4, "ai")
+	llm := newFakeLLM()
+	defer llm.Close()
+	web := newTestWeb()
+	defer web.Close()
+
+	err := os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := cassette.FromSpec(cassette.ModeRecord+":"+dir, hostTransport{
+		addrs: map[string]string{
+			"lemmy.test": lemmy.Listener.Addr().String(),
+			"llm.test":   llm.Listener.Addr().String(),
+			"news.test":  web.Listener.Addr().String(),
+		},
+		next: http.DefaultTransport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pasta article was posted before, the replay only knows that from
+	// the dump
+	db := store.OpenMemory()
+	defer db.Close()
+	err = db.CheckSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.MarkSeen("http://news.test/articles/pasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryPath := t.TempDir()
+	writeTestConfigs(t, binaryPath, "http://news.test/feed.xml", "random")
+	c := newTestClients(t, db, "http://lemmy.test", "http://llm.test")
+
+	err = recordRun(db, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := http.DefaultTransport
+	http.DefaultTransport = transport
+	defer func() {
+		http.DefaultTransport = previous
+	}()
+	allCurrentPosts, err := loadCurrentPosts(db, c.lemmy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runRSS(context.Background(), db, c, binaryPath, allCurrentPosts, report.New("test", "rss"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lemmy.Posts()) != 1 {
+		t.Fatalf("recorded %d posts, want 1", len(lemmy.Posts()))
+	}
+}
+
+// TestReplayRSS replays the recorded rss run. It fails if the run sends a
+// request which was not recorded, so run it with -record after a change of
+// the requests on purpose.
+func TestReplayRSS(t *testing.T) {
+	if *recordCassettes {
+		recordRSSCassette(t, rssCassette)
+	}
+
+	now := clock.Now
+	t.Cleanup(func() {
+		clock.Now = now
+	})
+	db, err := replayRun(rssCassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Like main, which refuses an outdated schema
+	err = db.CheckSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := cassette.FromSpec(cassette.ModeReplay+":"+rssCassette, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &requestLog{next: transport}
+	useTransport(t, log)
+
+	binaryPath := t.TempDir()
+	writeTestConfigs(t, binaryPath, "http://news.test/feed.xml", "random")
+	c := newTestClients(t, db, "http://lemmy.test", "http://llm.test")
+	allCurrentPosts, err := loadCurrentPosts(db, c.lemmy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runRSS(context.Background(), db, c, binaryPath, allCurrentPosts, report.New("test", "rss"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The random account is the one of the recording, else its
+	// registration would not be found
+	for _, request := range []string{
+		"POST http://lemmy.test/api/v3/user/register 200",
+		"POST http://lemmy.test/api/v3/post 200",
+	} {
+		if n := log.count(request); n != 1 {
+			t.Errorf("got %d times %q, want 1 in\n%v", n, request, log.requests)
+		}
+	}
+	for _, request := range log.requests {
+		if !strings.HasSuffix(request, " 200") {
+			t.Errorf("request %q failed", request)
+		}
+	}
+
+	seen, err := db.Seen("http://news.test/articles/llm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Errorf("posted article is not seen")
+	}
+	verdict, err := db.Verdict("http://news.test/articles/cooking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict == nil || verdict.Score != 3 {
+		t.Fatalf("verdict %+v, want the rejection of the cooking article", verdict)
+	}
+	if !verdict.Created.Equal(clock.Now()) {
+		t.Errorf("verdict created %s, want the start of the recording %s", verdict.Created, clock.Now())
+	}
+}
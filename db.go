@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"newsbots/pkg/store"
+	"os"
+)
+
+// runDB runs the 'db' subcommands. They work on a db of any schema version.
+func runDB(db *store.Store, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect a db subcommand: 'version', 'migrate', 'dump' or 'restore'")
+	}
+
+	switch args[0] {
+	case "version":
+		version, err := db.Schema()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema %d, current %d\n", version, store.SchemaVersion)
+		return nil
+	case "migrate":
+		n, err := db.Migrate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Migrated %d keys to schema %d\n", n, store.SchemaVersion)
+		return nil
+	case "dump":
+		// Dump to stdout without a file, for piping into jq
+		var w io.Writer = os.Stdout
+		if len(args) > 1 {
+			dumpFile, err := os.Create(args[1])
+			if err != nil {
+				return fmt.Errorf("could not create dump file: %w", err)
+			}
+			defer dumpFile.Close()
+			w = dumpFile
+		}
+		n, err := db.Dump(w)
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			fmt.Printf("Dumped %d keys to %s\n", n, args[1])
+		}
+		return nil
+	case "restore":
+		if len(args) < 2 {
+			return fmt.Errorf("expect a dump file: db restore <file>")
+		}
+		dumpFile, err := os.Open(args[1])
+		if err != nil {
+			return fmt.Errorf("could not open dump file: %w", err)
+		}
+		defer dumpFile.Close()
+		n, err := db.Restore(dumpFile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d keys from %s\n", n, args[1])
+		return nil
+	default:
+		return fmt.Errorf("no valid db subcommand %q. Expect 'version', 'migrate', 'dump' or 'restore'", args[0])
+	}
+}
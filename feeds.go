@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"newsbots/pkg/posts"
+	"newsbots/pkg/posts/rss"
+	"newsbots/pkg/store"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const feedsSubcommands = "'list', 'add', 'edit', 'enable', 'disable', 'remove', 'history', 'rollback', 'import', 'health', 'discover', 'import-opml' or 'export-opml'"
+
+// runFeeds runs the 'feeds' subcommands.
+func runFeeds(db *store.Store, binaryPath string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect a feeds subcommand: %s", feedsSubcommands)
+	}
+
+	// Subcommands which need an argument
+	switch args[0] {
+	case "add", "edit", "enable", "disable", "remove", "rollback", "discover", "import-opml":
+		if len(args) < 2 {
+			return fmt.Errorf("expect an argument: feeds %s <%s>", args[0], feedsArgument(args[0]))
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		return printFeeds(db, binaryPath)
+	case "add":
+		return addFeed(db, binaryPath, args[1], args[2:])
+	case "edit":
+		return editFeed(db, binaryPath, "edit", args[1], args[2:])
+	case "enable":
+		return editFeed(db, binaryPath, "enable", args[1], []string{"disabled=false"})
+	case "disable":
+		return editFeed(db, binaryPath, "disable", args[1], []string{"disabled=true"})
+	case "remove":
+		return removeFeed(db, binaryPath, args[1])
+	case "history":
+		feedURL := ""
+		if len(args) > 1 {
+			feedURL = args[1]
+		}
+		return printFeedHistory(db, feedURL)
+	case "rollback":
+		change, err := rollbackFeed(db, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %s of %s\n", change.Action, change.Feed)
+		return nil
+	case "import":
+		jsonPath := path.Join(binaryPath, "rss_feeds.json")
+		if len(args) > 1 {
+			jsonPath = args[1]
+		}
+		return importFeeds(db, binaryPath, jsonPath)
+	case "health":
+		return printFeedHealth(db, binaryPath)
+	case "discover":
+		return discoverFeeds(binaryPath, args[1])
+	case "import-opml":
+		return importOPML(db, binaryPath, args[1])
+	case "export-opml":
+		opmlPath := ""
+		if len(args) > 1 {
+			opmlPath = args[1]
+		}
+		return exportOPML(db, binaryPath, opmlPath)
+	default:
+		return fmt.Errorf("no valid feeds subcommand %q. Expect %s", args[0], feedsSubcommands)
+	}
+}
+
+func feedsArgument(subcommand string) string {
+	switch subcommand {
+	case "rollback":
+		return "change-id"
+	case "discover":
+		return "site-url"
+	case "import-opml":
+		return "file"
+	}
+	return "feed-url"
+}
+
+// printFeeds prints the configured feeds.
+func printFeeds(db *store.Store, binaryPath string) error {
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tUSERNAME\tSTATE\tPOLL EVERY\tCHECK TITLE\tCHECK CONTENT\tMAX ITEMS\tPRIORITY")
+	for _, feedConfig := range feedConfigs {
+		state := "enabled"
+		if feedConfig.Disabled {
+			state = "disabled"
+		}
+		pollEvery, ok := feedOption(feedConfig, "poll_every")
+		if !ok {
+			pollEvery = "-"
+		}
+		maxItems, ok := feedOption(feedConfig, "max_items")
+		if !ok {
+			maxItems = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%t\t%s\t%d\n", feedConfig.URL, feedConfig.Username, state,
+			pollEvery, feedConfig.CheckTitle, feedConfig.CheckLinkContent, maxItems, feedConfig.Priority)
+	}
+	return w.Flush()
+}
+
+// addFeed adds a feed with the options given as name=value. Without
+// options the checks are enabled and the username is derived from the url.
+func addFeed(db *store.Store, binaryPath string, feedURL string, options []string) error {
+	// Seed first, else the new feed would keep rss_feeds.json from seeding
+	_, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	existing, err := getFeed(db, feedURL)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("feed %q exists already, use 'feeds edit'", feedURL)
+	}
+
+	feedConfig := RSSFeedConfig{
+		URL:              feedURL,
+		CheckTitle:       true,
+		CheckLinkContent: true,
+		Username:         discoverUsername(feedURL),
+	}
+	err = setFeedOptions(&feedConfig, options)
+	if err != nil {
+		return err
+	}
+	return saveFeed(db, "add", feedURL, &feedConfig)
+}
+
+// editFeed sets the options given as name=value.
+func editFeed(db *store.Store, binaryPath string, action string, feedURL string, options []string) error {
+	_, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	feedConfig, err := getFeed(db, feedURL)
+	if err != nil {
+		return err
+	}
+	if feedConfig == nil {
+		return fmt.Errorf("feed %q not found", feedURL)
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("expect options to change, like check_title=false")
+	}
+
+	err = setFeedOptions(feedConfig, options)
+	if err != nil {
+		return err
+	}
+	return saveFeed(db, action, feedURL, feedConfig)
+}
+
+func removeFeed(db *store.Store, binaryPath string, feedURL string) error {
+	_, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	feedConfig, err := getFeed(db, feedURL)
+	if err != nil {
+		return err
+	}
+	if feedConfig == nil {
+		return fmt.Errorf("feed %q not found", feedURL)
+	}
+	return saveFeed(db, "remove", feedURL, nil)
+}
+
+func setFeedOptions(feedConfig *RSSFeedConfig, options []string) error {
+	for _, option := range options {
+		name, value, found := strings.Cut(option, "=")
+		if !found {
+			return fmt.Errorf("expect option as name=value, got %q", option)
+		}
+		err := setFeedOption(feedConfig, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFeeds adds the feeds of a JSON file in the format of rss_feeds.json.
+// Feeds which are configured already are skipped.
+func importFeeds(db *store.Store, binaryPath string, jsonPath string) error {
+	feedConfigsJSON, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return fmt.Errorf("could not open feeds file: %w", err)
+	}
+	feedConfigs := make([]RSSFeedConfig, 0)
+	err = json.Unmarshal(feedConfigsJSON, &feedConfigs)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal feed configs: %w", err)
+	}
+
+	_, err = loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, feedConfig := range feedConfigs {
+		feedConfig := feedConfig
+		existing, err := getFeed(db, feedConfig.URL)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		err = saveFeed(db, "import", feedConfig.URL, &feedConfig)
+		if err != nil {
+			return err
+		}
+		imported++
+	}
+	fmt.Printf("Imported %d feeds\n", imported)
+	return nil
+}
+
+// printFeedHistory prints the changes of the feed, or of all feeds.
+func printFeedHistory(db *store.Store, feedURL string) error {
+	changes, err := feedHistory(db, feedURL)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE ID\tTIME\tWHO\tACTION\tFEED\tWHAT")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", change.ID, change.Time.Format(time.RFC3339),
+			change.Who, change.Action, change.Feed, describeChange(change))
+	}
+	return w.Flush()
+}
+
+// describeChange lists the options which differ before and after the change.
+func describeChange(change FeedChange) string {
+	switch {
+	case change.Before == nil && change.After == nil:
+		return ""
+	case change.Before == nil:
+		return "added"
+	case change.After == nil:
+		return "removed"
+	}
+	diffs := make([]string, 0)
+	for _, name := range feedOptionNames {
+		before, _ := feedOption(*change.Before, name)
+		after, _ := feedOption(*change.After, name)
+		if before != after {
+			diffs = append(diffs, fmt.Sprintf("%s: %q -> %q", name, before, after))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// printFeedHealth prints the fetch history of every configured feed.
+func printFeedHealth(db *store.Store, binaryPath string) error {
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	healths, err := rss.ListHealth(db)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tSTATE\tLAST SUCCESS\tFAILURES\tLAST STATUS\tITEMS/DAY\tACCEPTED\tLAST ERROR")
+	for _, feedConfig := range feedConfigs {
+		h, found := healths[feedConfig.URL]
+		if !found {
+			fmt.Fprintf(w, "%s\tnever fetched\t-\t-\t-\t-\t-\t\n", feedConfig.URL)
+			continue
+		}
+
+		state := "ok"
+		switch {
+		case !h.Allow(now):
+			state = "open until " + h.NextProbe.Format(time.RFC3339)
+		case !h.NextProbe.IsZero():
+			state = "probing"
+		case h.ConsecutiveFailures > 0:
+			state = "failing"
+		}
+		lastSuccess := "never"
+		if !h.LastSuccess.IsZero() {
+			lastSuccess = h.LastSuccess.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.1f\t%.0f%%\t%s\n",
+			feedConfig.URL, state, lastSuccess, h.ConsecutiveFailures, h.LastStatus,
+			h.ItemsPerDay(now), h.AcceptanceRate()*100, h.LastError)
+	}
+	return w.Flush()
+}
+
+// Number of latest items shown per discovered feed
+const discoverPreviewItems = 10
+
+// discoverFeeds finds the feeds of a website, previews their latest items
+// through the title keyword filter and prints a config entry for each feed,
+// ready to paste into rss_feeds.json.
+func discoverFeeds(binaryPath string, siteURL string) error {
+	keywords, err := posts.LoadKeywordMatcher(path.Join(binaryPath, "ai_keywords.json"))
+	if err != nil {
+		return fmt.Errorf("could not LoadKeywordMatcher: %w", err)
+	}
+
+	feeds, err := rss.Discover(siteURL)
+	if err != nil {
+		return err
+	}
+	if len(feeds) == 0 {
+		return fmt.Errorf("no feed found for %q", siteURL)
+	}
+
+	for _, feed := range feeds {
+		preview := feed.Posts
+		if len(preview) > discoverPreviewItems {
+			preview = preview[:discoverPreviewItems]
+		}
+		matching := make(map[string]bool)
+		for _, p := range posts.FilterPostsByAIKeywordsInTitle(preview, keywords) {
+			matching[p.Url] = true
+		}
+
+		fmt.Printf("Feed %s (%d items, %d of the latest %d match the AI keywords)\n",
+			feed.URL, len(feed.Posts), len(matching), len(preview))
+		for _, p := range preview {
+			mark := " "
+			if matching[p.Url] {
+				mark = "+"
+			}
+			fmt.Printf("  %s %s\n", mark, p.Title)
+		}
+
+		// Feeds about AI only do not need the title check
+		feedConfig := RSSFeedConfig{
+			URL:              feed.URL,
+			CheckTitle:       len(matching) < len(preview),
+			CheckLinkContent: true,
+			Username:         discoverUsername(feed.URL),
+			PollEvery:        "1h",
+		}
+		feedConfigJSON, err := json.MarshalIndent(feedConfig, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not marshal feed config: %w", err)
+		}
+		fmt.Printf("%s\n\n", feedConfigJSON)
+	}
+	return nil
+}
+
+// discoverUsername derives a bot username from the host of the feed, like
+// "openai_com" for "https://openai.com/blog/rss.xml".
+func discoverUsername(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	return strings.NewReplacer(".", "_", "-", "_").Replace(host)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"newsbots/pkg/store"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// FeedChange is one entry of the feed config history. Before is nil for an
+// added feed and After is nil for a removed one.
+type FeedChange struct {
+	ID     string         `json:"id"`
+	Time   time.Time      `json:"time"`
+	Who    string         `json:"who"`
+	Action string         `json:"action"`
+	Feed   string         `json:"feed"`
+	Before *RSSFeedConfig `json:"before,omitempty"`
+	After  *RSSFeedConfig `json:"after,omitempty"`
+}
+
+// loadFeeds returns the feed configs from the db. On the first run the db is
+// seeded from rss_feeds.json, only once, so removed feeds stay removed.
+func loadFeeds(db *store.Store, binaryPath string) ([]RSSFeedConfig, error) {
+	seeded, err := db.FeedsSeeded()
+	if err != nil {
+		return nil, err
+	}
+	if seeded {
+		return listFeeds(db)
+	}
+
+	feedConfigs, err := loadFeedConfigs(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, feedConfig := range feedConfigs {
+		feedConfig := feedConfig
+		err = saveFeed(db, "seed", feedConfig.URL, &feedConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = db.SetFeedsSeeded()
+	if err != nil {
+		return nil, err
+	}
+	return listFeeds(db)
+}
+
+var (
+	feedConfigBucket = store.Bucket[RSSFeedConfig]{Prefix: store.FeedConfigPrefix}
+	feedChangeBucket = store.Bucket[FeedChange]{Prefix: store.FeedHistoryPrefix}
+)
+
+// listFeeds returns the feed configs in the db, sorted by url.
+func listFeeds(db *store.Store) ([]RSSFeedConfig, error) {
+	all, err := feedConfigBucket.All(db)
+	if err != nil {
+		return nil, fmt.Errorf("could not list feeds: %w", err)
+	}
+	configs := make([]RSSFeedConfig, 0, len(all))
+	for _, feedConfig := range all {
+		configs = append(configs, *feedConfig)
+	}
+	return configs, nil
+}
+
+// getFeed returns the config of the feed, or nil if there is none.
+func getFeed(db *store.Store, feedURL string) (*RSSFeedConfig, error) {
+	feedConfig, err := feedConfigBucket.Load(db, feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not get feed from db: %w", err)
+	}
+	return feedConfig, nil
+}
+
+// saveFeed sets the config of the feed, or removes it if feedConfig is nil,
+// and records the change in the history.
+func saveFeed(db *store.Store, action string, feedURL string, feedConfig *RSSFeedConfig) error {
+	return db.Update(func(tx *store.Tx) error {
+		before, err := feedConfigBucket.Get(tx, feedURL)
+		if err != nil {
+			return fmt.Errorf("could not get feed from db: %w", err)
+		}
+
+		if feedConfig == nil {
+			err = feedConfigBucket.Delete(tx, feedURL)
+		} else {
+			err = feedConfigBucket.Put(tx, feedURL, feedConfig)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		change := FeedChange{
+			ID:     strconv.FormatInt(now.UnixNano(), 10),
+			Time:   now,
+			Who:    editor(),
+			Action: action,
+			Feed:   feedURL,
+			Before: before,
+			After:  feedConfig,
+		}
+		return feedChangeBucket.Put(tx, change.ID, &change)
+	})
+}
+
+// feedHistory returns the changes of the feed, or of all feeds if feedURL is
+// empty, oldest first.
+func feedHistory(db *store.Store, feedURL string) ([]FeedChange, error) {
+	changes := make([]FeedChange, 0)
+	err := db.View(func(tx *store.Tx) error {
+		return feedChangeBucket.Each(tx, func(id string, change *FeedChange) error {
+			if feedURL == "" || change.Feed == feedURL {
+				changes = append(changes, *change)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list feed history: %w", err)
+	}
+	return changes, nil
+}
+
+// rollbackFeed restores the config of the feed from before the change. The
+// rollback is a change in the history itself.
+func rollbackFeed(db *store.Store, changeID string) (*FeedChange, error) {
+	change, err := feedChangeBucket.Load(db, changeID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get feed change from db: %w", err)
+	}
+	if change == nil {
+		return nil, fmt.Errorf("change %q not found", changeID)
+	}
+
+	err = saveFeed(db, "rollback "+changeID, change.Feed, change.Before)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// editor returns who changes the feeds, from FEEDS_EDITOR or the system user.
+func editor() string {
+	for _, name := range []string{"FEEDS_EDITOR", "USER", "LOGNAME"} {
+		if who := os.Getenv(name); who != "" {
+			return who
+		}
+	}
+	return "unknown"
+}
+
+// feedOptionNames are the options of a feed besides its url, named like the
+// JSON fields.
+var feedOptionNames = []string{
+	"username", "check_title", "check_link_content", "use_reader", "title_regex",
+	"title_not_regex", "title_regex_remove", "max_items", "spread", "poll_every", "priority", "disabled",
+}
+
+// feedOption returns the option of the feed config as text. Options which
+// are not set return false.
+func feedOption(feedConfig RSSFeedConfig, name string) (string, bool) {
+	switch name {
+	case "username":
+		return feedConfig.Username, true
+	case "check_title":
+		return strconv.FormatBool(feedConfig.CheckTitle), true
+	case "check_link_content":
+		return strconv.FormatBool(feedConfig.CheckLinkContent), true
+	case "use_reader":
+		return strconv.FormatBool(feedConfig.UseReader), true
+	case "title_regex":
+		return optionalString(feedConfig.TitleRegex)
+	case "title_not_regex":
+		return optionalString(feedConfig.TitleNotRegex)
+	case "title_regex_remove":
+		return optionalString(feedConfig.TitleRegexRemove)
+	case "max_items":
+		return optionalInt(feedConfig.MaxItems)
+	case "spread":
+		return optionalInt(feedConfig.Spread)
+	case "poll_every":
+		return feedConfig.PollEvery, feedConfig.PollEvery != ""
+	case "priority":
+		return strconv.Itoa(feedConfig.Priority), feedConfig.Priority != 0
+	case "disabled":
+		return strconv.FormatBool(feedConfig.Disabled), feedConfig.Disabled
+	}
+	return "", false
+}
+
+// setFeedOption parses and sets the option of the feed config. An empty
+// value unsets the optional ones.
+func setFeedOption(feedConfig *RSSFeedConfig, name, value string) error {
+	var err error
+	switch name {
+	case "username":
+		feedConfig.Username = value
+	case "check_title":
+		feedConfig.CheckTitle, err = strconv.ParseBool(value)
+	case "check_link_content":
+		feedConfig.CheckLinkContent, err = strconv.ParseBool(value)
+	case "use_reader":
+		feedConfig.UseReader, err = strconv.ParseBool(value)
+	case "title_regex":
+		feedConfig.TitleRegex, err = parseOptionalRegex(value)
+	case "title_not_regex":
+		feedConfig.TitleNotRegex, err = parseOptionalRegex(value)
+	case "title_regex_remove":
+		feedConfig.TitleRegexRemove, err = parseOptionalRegex(value)
+	case "max_items":
+		feedConfig.MaxItems, err = parseOptionalInt(value)
+	case "spread":
+		feedConfig.Spread, err = parseOptionalInt(value)
+	case "poll_every":
+		feedConfig.PollEvery = value
+		_, err = feedConfig.pollInterval()
+	case "priority":
+		feedConfig.Priority, err = strconv.Atoi(value)
+	case "disabled":
+		feedConfig.Disabled, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown feed option %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse %s: %w", name, err)
+	}
+	return nil
+}
+
+func optionalString(s *string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	return *s, true
+}
+
+func optionalInt(i *int) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	return strconv.Itoa(*i), true
+}
+
+func parseOptionalRegex(value string) (*string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	_, err := regexp.Compile(value)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+func parseOptionalInt(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
@@ -3,12 +3,12 @@ module newsbots
 go 1.21.1
 
 require (
+	github.com/PuerkitoBio/goquery v1.8.0
 	github.com/k3a/html2text v1.2.1
 	jaytaylor.com/html2text v0.0.0-20230321000545-74c2419ad056
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.0 // indirect
 	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgraph-io/ristretto v0.1.1 // indirect
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging sets the default slog logger with a new run ID. It returns
+// the logger without run ID and the run ID. LOG_FORMAT=json switches to
+// JSON lines for shipping, LOG_LEVEL=debug enables debug logs.
+func setupLogging(command string) (*slog.Logger, string) {
+	handlerOptions := &slog.HandlerOptions{}
+	if strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug") {
+		handlerOptions.Level = slog.LevelDebug
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, handlerOptions)
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOptions)
+	}
+
+	logger := slog.New(handler).With("command", command)
+	runID := newRunID()
+	slog.SetDefault(logger.With("run_id", runID))
+	return logger, runID
+}
+
+// newRunID returns a random ID that is attached to every log line of a run.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// fatal logs the error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
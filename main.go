@@ -1,23 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"newsbots/pkg/aiapipro"
+	"newsbots/pkg/cassette"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/metrics"
 	"newsbots/pkg/posts"
 	"newsbots/pkg/posts/rss"
+	"newsbots/pkg/publish"
+	"newsbots/pkg/queue"
+	"newsbots/pkg/report"
+	"newsbots/pkg/store"
 	"os"
 	"path"
 	"regexp"
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/dgraph-io/badger/v4"
 )
 
 func init() {
@@ -35,6 +42,20 @@ type RSSFeedConfig struct {
 	MaxItems         *int    `json:"max_items,omitempty"`
 	Spread           *int    `json:"spread,omitempty"`
 	UseReader        bool    `json:"use_reader"`
+	// PollEvery is the time between two fetches of the feed, like "30m".
+	// Each feed gets a fixed slot in the interval. Empty means every rss run.
+	PollEvery string `json:"poll_every,omitempty"`
+	// Priority orders the publishing of prepared posts, higher first
+	Priority int `json:"priority,omitempty"`
+	// Disabled feeds stay in the store, but are not fetched
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func (c RSSFeedConfig) pollInterval() (time.Duration, error) {
+	if c.PollEvery == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.PollEvery)
 }
 
 type ModerareRules struct {
@@ -74,364 +95,780 @@ type PublicationInfo struct {
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("expect at least one command line argument")
+		fatal("expect at least one command line argument")
 	}
+	baseLogger, runID := setupLogging(os.Args[1])
 
 	binaryPath, err := os.Executable()
 	if err != nil {
-		log.Fatal("could not os.Executable", err)
+		fatal("could not os.Executable", "error", err)
 	}
 	binaryPath = path.Dir(binaryPath)
 
-	opts := badger.DefaultOptions(path.Join(binaryPath, "badger.db"))
-	db, err := badger.Open(opts)
+	if os.Args[1] == "secrets" {
+		err = runSecrets(binaryPath, os.Args[2:])
+		if err != nil {
+			fatal("could not run secrets command", "error", err)
+		}
+		return
+	}
+	provider, err := secretsProvider(binaryPath)
 	if err != nil {
-		log.Fatal("could not badger open db:", err)
+		fatal("could not load secrets", "error", err)
+	}
+	secretValues, err := requireSecrets(provider, os.Args[1])
+	if err != nil {
+		fatal("could not start", "error", err)
 	}
-	defer db.Close()
 
-	// Load all current posts
-	allCurrentPosts, err := aiapipro.GetPosts()
+	// Record or replay every outgoing request, like HTTP_CASSETTE=replay:dir.
+	// A replay runs on the db of the recording, in memory.
+	var cassetteTransport *cassette.Transport
+	if cassetteSpec := os.Getenv("HTTP_CASSETTE"); cassetteSpec != "" {
+		cassetteTransport, err = cassette.FromSpec(cassetteSpec, http.DefaultTransport)
+		if err != nil {
+			fatal("could not open cassette", "error", err)
+		}
+	}
+
+	var db *store.Store
+	if cassetteTransport != nil && cassetteTransport.Mode == cassette.ModeReplay {
+		db, err = replayRun(cassetteTransport.Dir)
+	} else {
+		db, err = store.Open(path.Join(binaryPath, "badger.db"))
+	}
 	if err != nil {
-		fmt.Println("could not GetPostIDs", err)
+		fatal("could not open db", "error", err)
+	}
+	defer db.Close()
+
+	// The db commands also work on an outdated schema
+	if os.Args[1] == "db" {
+		err = runDB(db, os.Args[2:])
+		if err != nil {
+			fatal("could not run db command", "error", err)
+		}
 		return
 	}
+	err = db.CheckSchema()
+	if err != nil {
+		fatal("could not use db", "error", err)
+	}
 
-	// Write already posted to db
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
-	for _, p := range allCurrentPosts {
-		key := "post+" + p.URL
-		err = txn.Set([]byte(key), []byte(p.URL))
+	c, err := newClients(db, secretValues)
+	if err != nil {
+		fatal("could not create clients", "error", err)
+	}
+
+	if cassetteTransport != nil {
+		if cassetteTransport.Mode == cassette.ModeRecord {
+			err = recordRun(db, cassetteTransport.Dir)
+			if err != nil {
+				fatal("could not record run", "error", err)
+			}
+		}
+		http.DefaultTransport = cassetteTransport
+		slog.Info("using cassette", "mode", cassetteTransport.Mode, "dir", cassetteTransport.Dir)
+	}
+
+	// Count every outgoing request, also the ones of gofeed and html2text
+	http.DefaultTransport = &metrics.Transport{Next: http.DefaultTransport}
+
+	// Commands without the current posts
+	switch os.Args[1] {
+	case "serve":
+		err = serve(db, c, binaryPath, baseLogger)
 		if err != nil {
-			log.Fatal(fmt.Errorf("could not set current posto db: %w", err))
+			slog.Error("could not serve", "error", err)
 		}
+		return
+	case "next-runs":
+		err = printNextRuns(db, binaryPath)
+		if err != nil {
+			fatal("could not printNextRuns", "error", err)
+		}
+		return
+	case "feeds":
+		err = runFeeds(db, binaryPath, os.Args[2:])
+		if err != nil {
+			fatal("could not run feeds command", "error", err)
+		}
+		return
+	case "accounts":
+		err = runAccounts(c, os.Args[2:])
+		if err != nil {
+			fatal("could not run accounts command", "error", err)
+		}
+		return
+	case "queue":
+		err = runQueue(db, c, binaryPath, os.Args[2:])
+		if err != nil {
+			fatal("could not run queue command", "error", err)
+		}
+		return
+	case "runs":
+		err = printRuns(db, os.Args[2:])
+		if err != nil {
+			fatal("could not printRuns", "error", err)
+		}
+		return
+	case "verdicts":
+		err = runVerdicts(db, c, os.Args[2:])
+		if err != nil {
+			fatal("could not run verdicts command", "error", err)
+		}
+		return
 	}
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		log.Fatal(fmt.Errorf("could not commit current posts to db: %w", err))
+
+	// Load all current posts
+	allCurrentPosts, err := loadCurrentPosts(db, c.lemmy)
+	if err != nil {
+		slog.Error("could not loadCurrentPosts", "error", err)
+		return
 	}
 
 	// Exec argument
+	ctx := context.Background()
 	switch os.Args[1] {
 	case "sitemap":
 		sitemapPath := "sitemap.xml"
 		if len(os.Args) > 2 {
 			sitemapPath = os.Args[2]
 		}
-		newsSitemap, err := os.Create(sitemapPath)
+		err = runSitemap(allCurrentPosts, sitemapPath)
+	case "rss":
+		rep := report.New(runID, "rss")
+		err = runRSS(ctx, db, c, binaryPath, allCurrentPosts, rep)
+		rep.Finish()
+		reportPath := ""
+		if len(os.Args) > 2 {
+			reportPath = os.Args[2]
+		}
+		if reportErr := finishReport(db, rep, reportPath); reportErr != nil {
+			slog.Error("could not finishReport", "error", reportErr)
+		}
+	case "moderate":
+		_, err = runModerate(ctx, db, c, binaryPath, allCurrentPosts)
+	case "upvote":
+		runUpvote(db, c, allCurrentPosts)
+	default:
+		fatal("No valid command. Expect 'rss', 'moderate', 'sitemap', 'upvote', 'serve', 'next-runs', 'runs', 'feeds', 'accounts', 'queue', 'verdicts', 'db' or 'secrets'", "command", os.Args[1])
+	}
+	if err != nil {
+		fatal("could not run command", "error", err)
+	}
+
+	// One-shot runs hand their metrics to the node exporter textfile collector
+	if textfileDir := os.Getenv("METRICS_TEXTFILE_DIR"); textfileDir != "" {
+		metrics.Set("newsbots_last_run_timestamp_seconds", float64(time.Now().Unix()), "command", os.Args[1])
+		err = metrics.Default.WriteTextfile(path.Join(textfileDir, "newsbots_"+os.Args[1]+".prom"))
+		if err != nil {
+			slog.Error("could not WriteTextfile", "error", err)
+		}
+	}
+}
+
+// loadCurrentPosts loads all posts of the site and marks their urls as
+// posted in the db.
+func loadCurrentPosts(db *store.Store, lemmy *aiapipro.Client) ([]aiapipro.Post, error) {
+	allCurrentPosts, err := lemmy.GetPosts()
+	if err != nil {
+		return nil, fmt.Errorf("could not GetPosts: %w", err)
+	}
+
+	// Write already posted to db
+	urls := make([]string, 0, len(allCurrentPosts))
+	for _, p := range allCurrentPosts {
+		urls = append(urls, p.URL)
+	}
+	err = db.MarkSeen(urls...)
+	if err != nil {
+		return nil, fmt.Errorf("could not set current posts to db: %w", err)
+	}
+
+	return allCurrentPosts, nil
+}
+
+func runSitemap(allCurrentPosts []aiapipro.Post, sitemapPath string) error {
+	newsSitemap, err := os.Create(sitemapPath)
+	if err != nil {
+		return fmt.Errorf("could not create sitemap: %w", err)
+	}
+
+	sort.Slice(allCurrentPosts, func(i, j int) bool {
+		return allCurrentPosts[i].ID > allCurrentPosts[j].ID
+	})
+	defer newsSitemap.Close()
+
+	newsUrls := make([]NewsURL, len(allCurrentPosts))
+	for k, p := range allCurrentPosts {
+		if k > 30000 {
+			break
+		}
+		publishedDate, err := aiapipro.ParseTime(p.Published)
+		if err != nil {
+			slog.Warn("could not parse published date", "post_id", p.ID, "published", p.Published, "error", err)
+			continue
+		}
+		if p.Counts.NewestCommentTime == "" {
+			p.Counts.NewestCommentTime = p.Published
+		}
+		lastCommentDate, err := aiapipro.ParseTime(p.Counts.NewestCommentTime)
 		if err != nil {
-			log.Fatal("Could not create sitemap.xml", err)
+			slog.Warn("could not parse NewestCommentTime date", "post_id", p.ID, "newest_comment_time", p.Counts.NewestCommentTime, "error", err)
+			continue
 		}
+		newsUrls[k] = NewsURL{
+			Loc:     p.ApID,
+			LastMod: lastCommentDate.Format("2006-01-02"),
+			News: NewsInfo{
+				Publication: PublicationInfo{
+					Name:     "AI News (AI API Pro)",
+					Language: "en",
+				},
+				PublicationDate: publishedDate.Format("2006-01-02"),
+				Title:           p.Name,
+			},
+		}
+	}
 
-		sort.Slice(allCurrentPosts, func(i, j int) bool {
-			return allCurrentPosts[i].ID > allCurrentPosts[j].ID
-		})
-		newsUrls := make([]NewsURL, len(allCurrentPosts))
-		for k, p := range allCurrentPosts {
-			if k > 30000 {
-				break
-			}
-			publishedDate, err := time.Parse("2006-01-02T15:04:05.999999", p.Published)
+	// Create a sample Sitemap with multiple URLs
+	sitemap := NewsSitemap{
+		XMLNS:  "http://www.sitemaps.org/schemas/sitemap/0.9",
+		NewsNS: "http://www.google.com/schemas/sitemap-news/0.9",
+		URLs:   newsUrls,
+	}
+
+	// Marshal the struct into XML
+	xmlData, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal XML: %w", err)
+	}
+
+	// Print the XML
+	_, err = newsSitemap.WriteString(xml.Header + string(xmlData))
+	if err != nil {
+		return fmt.Errorf("could not write to sitemap: %w", err)
+	}
+	return nil
+
+}
+
+// loadFeedConfigs reads rss_feeds.json, which seeds the feeds in the db.
+func loadFeedConfigs(binaryPath string) ([]RSSFeedConfig, error) {
+	feedConfigsJSON, err := os.ReadFile(path.Join(binaryPath, "rss_feeds.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open 'rss_feeds.json': %w", err)
+	}
+	feedConfigs := make([]RSSFeedConfig, 0)
+	err = json.Unmarshal(feedConfigsJSON, &feedConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal feed configs: %w", err)
+	}
+	return feedConfigs, nil
+}
+
+// runRSS posts new articles of all feeds which are due. Feeds with a
+// poll_every are fetched once in their slot, feeds with only a spread are
+// fetched with that chance in percent.
+func runRSS(ctx context.Context, db *store.Store, c clients, binaryPath string, allCurrentPosts []aiapipro.Post, rep *report.Report) error {
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+
+	keywords, err := posts.LoadKeywordMatcher(path.Join(binaryPath, "ai_keywords.json"))
+	if err != nil {
+		return fmt.Errorf("could not LoadKeywordMatcher: %w", err)
+	}
+
+	router, err := aiapipro.LoadRouter(c.lemmy, c.llm, path.Join(binaryPath, "community_rules.json"))
+	if err != nil {
+		return fmt.Errorf("could not LoadRouter: %w", err)
+	}
+
+	// Train the local relevance classifier from the LLM verdicts, published
+	// and moderated posts in the db
+	classifier, err := posts.LoadClassifier(db)
+	if err != nil {
+		return fmt.Errorf("could not LoadClassifier: %w", err)
+	}
+
+	allRssPosts := make(posts.Posts, 0)
+	for _, feedConfig := range feedConfigs {
+		if ctx.Err() != nil {
+			break
+		}
+		if feedConfig.Disabled {
+			continue
+		}
+		logger := slog.With("feed", feedConfig.URL)
+		pollEvery, err := feedConfig.pollInterval()
+		if err != nil {
+			logger.Error("could not parse poll_every", "error", err)
+			rep.Error("ParsePollEvery")
+			continue
+		}
+		if pollEvery > 0 {
+			lastFetch, err := db.LastFetch(feedConfig.URL)
 			if err != nil {
-				log.Println("could not parse published date", p.Published, err)
+				logger.Error("could not LastFetch", "error", err)
+				rep.Error("LastFetch")
 				continue
 			}
-			if p.Counts.NewestCommentTime == "" {
-				p.Counts.NewestCommentTime = p.Published
+			if !rss.IsDue(feedConfig.URL, pollEvery, lastFetch, clock.Now()) {
+				continue
 			}
-			lastCommentDate, err := time.Parse("2006-01-02T15:04:05.999999", p.Counts.NewestCommentTime)
-			if err != nil {
-				log.Println("could not parse NewestCommentTime date", p.Published, err)
+		} else if feedConfig.Spread != nil {
+			// Random check if we skip
+			if rand.Intn(100) > *feedConfig.Spread {
+				logger.Info("skip as of spread")
 				continue
 			}
-			newsUrls[k] = NewsURL{
-				Loc:     p.ApID,
-				LastMod: lastCommentDate.Format("2006-01-02"),
-				News: NewsInfo{
-					Publication: PublicationInfo{
-						Name:     "AI News (AI API Pro)",
-						Language: "en",
-					},
-					PublicationDate: publishedDate.Format("2006-01-02"),
-					Title:           p.Name,
-				},
+		}
+		if feedConfig.Username == "" {
+			logger.Error("no username given")
+			rep.Error("NoUsername")
+			continue
+		}
+
+		health, err := rss.LoadHealth(db, feedConfig.URL)
+		if err != nil {
+			logger.Error("could not LoadHealth", "error", err)
+			rep.Error("LoadHealth")
+			continue
+		}
+		if !health.Allow(clock.Now()) {
+			logger.Info("skip as circuit breaker is open", "failures", health.ConsecutiveFailures, "next_probe", health.NextProbe)
+			continue
+		}
+
+		logger.Info("fetch feed")
+		err = db.SetLastFetch(feedConfig.URL, clock.Now())
+		if err != nil {
+			logger.Warn("could not SetLastFetch", "error", err)
+		}
+		fetchStart := time.Now()
+		rssPosts, err := rss.GetPostsFromRSS(feedConfig.URL)
+		metrics.Observe("newsbots_feed_fetch_duration_seconds", time.Since(fetchStart).Seconds(), "feed", feedConfig.URL)
+		if err != nil {
+			logger.Error("could not GetPostsFromRSS", "stage", "fetch", "error", err)
+			rep.Error("GetPostsFromRSS")
+			metrics.Add("newsbots_feed_fetch_errors_total", 1, "feed", feedConfig.URL)
+			health.RecordFailure(err, clock.Now())
+			if err := health.Save(db); err != nil {
+				logger.Warn("could not save feed health", "error", err)
 			}
+			continue
+		}
+		itemURLs := make([]string, 0, len(rssPosts))
+		for _, p := range rssPosts {
+			itemURLs = append(itemURLs, p.Url)
 		}
+		health.RecordSuccess(itemURLs, clock.Now())
+		if err := health.Save(db); err != nil {
+			logger.Warn("could not save feed health", "error", err)
+		}
+		recordStage(rep, feedConfig.URL, "fetch", len(rssPosts), len(rssPosts))
+
+		if feedConfig.MaxItems != nil {
+			if *feedConfig.MaxItems < len(rssPosts) {
+				logger.Info("got too many rss items, cut down", "stage", "max_items", "items", len(rssPosts), "max_items", *feedConfig.MaxItems)
 
-		// Create a sample Sitemap with multiple URLs
-		sitemap := NewsSitemap{
-			XMLNS:  "http://www.sitemaps.org/schemas/sitemap/0.9",
-			NewsNS: "http://www.google.com/schemas/sitemap-news/0.9",
-			URLs:   newsUrls,
+				in := len(rssPosts)
+				rssPosts = rssPosts[:*feedConfig.MaxItems-1]
+				recordStage(rep, feedConfig.URL, "max_items", in, len(rssPosts))
+			}
 		}
 
-		// Marshal the struct into XML
-		xmlData, err := xml.MarshalIndent(sitemap, "", "  ")
+		// Filter out posts, where too many where already posted
+		in := len(rssPosts)
+		rssPosts, err = aiapipro.FilterTooMuchPosted(db, 2, rssPosts, allCurrentPosts)
 		if err != nil {
-			log.Fatal("Could not marshal XML:", err)
+			logger.Error("could not FilterTooMuchPosted", "stage", "too_much_posted", "error", err)
+			rep.Error("FilterTooMuchPosted")
+			continue
 		}
+		recordStage(rep, feedConfig.URL, "too_much_posted", in, len(rssPosts))
 
-		// Print the XML
-		_, err = newsSitemap.WriteString(xml.Header + string(xmlData))
+		// Filter out the urls which already where posted
+		in = len(rssPosts)
+		rssPosts, err = aiapipro.FilterAlreadyPosted(db, rssPosts)
 		if err != nil {
-			log.Fatal("Could not write to sitemap.xml", err)
+			logger.Error("could not FilterAlreadyPosted", "stage", "already_posted", "error", err)
+			rep.Error("FilterAlreadyPosted")
+			continue
 		}
+		recordStage(rep, feedConfig.URL, "already_posted", in, len(rssPosts))
 
-	case "rss":
-		feedConfigsJSON, err := os.ReadFile(path.Join(binaryPath, "rss_feeds.json"))
+		// Filter out the urls which the LLM rejected lately
+		in = len(rssPosts)
+		rssPosts, err = posts.FilterRejected(db, rssPosts)
 		if err != nil {
-			log.Fatal("could not open 'rss_feeds.json", err)
+			logger.Error("could not FilterRejected", "stage", "rejected", "error", err)
+			rep.Error("FilterRejected")
+			continue
+		}
+		recordStage(rep, feedConfig.URL, "rejected", in, len(rssPosts))
+
+		if feedConfig.CheckTitle {
+			in = len(rssPosts)
+			rssPosts = posts.FilterPostsByAIKeywordsInTitle(rssPosts, keywords)
+			recordStage(rep, feedConfig.URL, "title_keywords", in, len(rssPosts))
 		}
-		feedConfigs := make([]RSSFeedConfig, 0)
-		err = json.Unmarshal(feedConfigsJSON, &feedConfigs)
+
+		if feedConfig.TitleRegex != nil {
+			in = len(rssPosts)
+			titleRegexp := regexp.MustCompile(*feedConfig.TitleRegex)
+			rssPosts = posts.FilterPostsByTitleRegex(rssPosts, titleRegexp, true)
+			recordStage(rep, feedConfig.URL, "title_regex", in, len(rssPosts))
+		}
+
+		in = len(rssPosts)
+		rssPosts, err = posts.EnrichPostsWithExcerpt(rssPosts)
 		if err != nil {
-			log.Fatal("could not unmarshal feed configs:", err)
+			logger.Error("could not EnrichPostsWithExcerpt", "stage", "excerpt", "error", err)
+			rep.Error("EnrichPostsWithExcerpt")
+			continue
 		}
+		recordStage(rep, feedConfig.URL, "excerpt", in, len(rssPosts))
 
-		allRssPosts := make(posts.Posts, 0)
-		for _, feedConfig := range feedConfigs {
-			if feedConfig.Spread != nil {
-				// Random check if we skip
-				if rand.Intn(100) > *feedConfig.Spread {
-					log.Print("Skip as of spread", feedConfig.URL)
-				}
-			}
-			log.Println(feedConfig.URL)
-			if feedConfig.Username == "" {
-				log.Print("not username given for %q", feedConfig.URL)
-				continue
-			}
-			rssPosts, err := rss.GetPostsFromRSS(feedConfig.URL)
+		if feedConfig.CheckLinkContent {
+			in = len(rssPosts)
+			rssPosts, err = posts.FilterPostsByAIContent(db, c.llm, keywords, classifier, rssPosts)
 			if err != nil {
-				log.Print("could not GetPostsFromRSS for url %q: %s", feedConfig.URL, err)
+				logger.Error("could not FilterPostsByAIContent", "stage", "ai_content", "error", err)
+				rep.Error("FilterPostsByAIContent")
 				continue
 			}
+			recordStage(rep, feedConfig.URL, "ai_content", in, len(rssPosts))
+		}
 
-			if feedConfig.MaxItems != nil {
-				if *feedConfig.MaxItems < len(rssPosts) {
-					log.Printf("Got too many rss items %d, cut down to %d", len(rssPosts), *feedConfig.MaxItems)
+		// The classifier learns from the feed text, not the rewritten one
+		for k, p := range rssPosts {
+			rssPosts[k].SourceText = posts.ClassifierText(p.Title, p.Excerpt)
+		}
 
-					rssPosts = rssPosts[:*feedConfig.MaxItems-1]
-				}
+		if feedConfig.TitleRegexRemove != nil {
+			r := regexp.MustCompile(*feedConfig.TitleRegexRemove)
+			for k, p := range rssPosts {
+				rssPosts[k].Title = r.ReplaceAllString(p.Title, "")
 			}
+		}
 
-			// Filter out posts, where too many where already posted
-			rssPosts, err = aiapipro.FilterTooMuchPosted(db, 2, rssPosts, allCurrentPosts)
+		// Post articles. The session of the account is cached, so every
+		// account logs in once per run.
+		account := feedConfig.Username
+		if feedConfig.Username == "random" {
+			account, err = c.lemmy.RandomAccount()
 			if err != nil {
-				log.Print("could not FilterTooMuchPosted:", err)
+				logger.Error("could not RandomAccount", "error", err)
+				rep.Error("RandomAccount")
 				continue
 			}
-
-			// Filter out the urls which already where posted
-			rssPosts, err = aiapipro.FilterAlreadyPosted(db, rssPosts)
+		} else {
+			_, err = c.lemmy.Session(account)
 			if err != nil {
-				log.Print("could not FilterAlreadyPosted:", err)
+				logger.Error("could not login", "user", account, "error", err)
+				rep.Error("LoginUser")
 				continue
 			}
-
-			if feedConfig.CheckTitle {
-				rssPosts = posts.FilterPostsByAIKeywordsInTitle(rssPosts)
+		}
+		for _, p := range rssPosts {
+			if feedConfig.UseReader {
+				p.Url = fmt.Sprintf("https://reader.aiapipro.com/?url=%s", p.Url)
 			}
+			p.Account = account
+			p.Feed = feedConfig.URL
+			p.Priority = feedConfig.Priority
 
-			if feedConfig.TitleRegex != nil {
-				titleRegexp := regexp.MustCompile(*feedConfig.TitleRegex)
-				rssPosts = posts.FilterPostsByTitleRegex(rssPosts, titleRegexp, true)
-			}
+			allRssPosts = append(allRssPosts, p)
+		}
+	}
 
-			rssPosts, err = posts.EnrichPostsWithExcerpt(rssPosts)
-			if err != nil {
-				log.Print("could not EnrichPostsWithExcerpt:", err)
-				continue
-			}
+	rand.Shuffle(len(allRssPosts), func(i, j int) {
+		allRssPosts[i], allRssPosts[j] = allRssPosts[j], allRssPosts[i]
+	})
 
-			if feedConfig.CheckLinkContent {
-				rssPosts, err = posts.FilterPostsByAIContent(db, rssPosts)
-				if err != nil {
-					log.Print("could not FilterPostsByAIContent:", err)
-					continue
-				}
-			}
+	// Prepared posts go through the queue, so a failed publish does not
+	// lose the LLM work
+	for _, p := range allRssPosts {
+		if ctx.Err() != nil {
+			break
+		}
+		recordStage(rep, p.Feed, "prepare", 1, 0)
+		logger := p.Logger("prepare")
 
-			if feedConfig.TitleRegexRemove != nil {
-				r := regexp.MustCompile(*feedConfig.TitleRegexRemove)
-				for k, p := range rssPosts {
-					rssPosts[k].Title = r.ReplaceAllString(p.Title, "")
-				}
-			}
+		resp, err := http.Get(p.Url)
+		if err != nil {
+			logger.Warn("could not get url", "error", err)
+			rep.Error("GetURL")
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			logger.Info("dropped, url not reachable", "status", resp.StatusCode)
+			continue
+		}
 
-			// Post articles
-			var jwt string
-			if feedConfig.Username == "random" {
-				jwt, err = aiapipro.GetRandomAuthenticateUserToken(db)
-				if err != nil {
-					log.Print("could not GetAuthenticateUserToken:", err)
-					continue
-				}
-				if jwt == "" {
-					log.Print("did get empty jwt")
-					continue
-				}
-			} else {
-				jwt, err = aiapipro.LoginUser(feedConfig.Username)
-				if err != nil {
-					log.Print("could not GetAuthenticateUserToken:", err)
-					continue
-				}
-			}
-			for _, p := range rssPosts {
-				if feedConfig.UseReader {
-					p.Url = fmt.Sprintf("https://reader.aiapipro.com/?url=%s", p.Url)
-				}
-				p.JWT = jwt
+		p.Description, err = c.llm.SummarizeArticle(p.Title, p.Excerpt)
+		if err != nil {
+			logger.Error("could not PromptBetterSummarizeArticle", "error", err)
+			rep.Error("PromptBetterSummarizeArticle")
+			continue
+		}
+		p.Title, err = c.llm.RephraseTitle(p.Title, "")
+		if err != nil {
+			logger.Error("could not PromptBetterRephraseTitle", "error", err)
+			rep.Error("PromptBetterRephraseTitle")
+			continue
+		}
 
-				allRssPosts = append(allRssPosts, p)
+		// Keep the paid summary and title, an unrouted post goes to the
+		// default community
+		p.CommunityID, err = router.Route(&p)
+		if err != nil {
+			logger.Warn("could not Route, use default community", "error", err)
+			rep.Error("Route")
+			p.CommunityID, err = router.Default()
+			if err != nil {
+				logger.Warn("could not resolve default community", "error", err)
+				p.CommunityID = 0
 			}
 		}
 
-		rand.Shuffle(len(allRssPosts), func(i, j int) {
-			allRssPosts[i], allRssPosts[j] = allRssPosts[j], allRssPosts[i]
-		})
+		err = queue.Enqueue(db, p)
+		if err != nil {
+			logger.Error("could not Enqueue", "error", err)
+			rep.Error("Enqueue")
+			continue
+		}
+		recordStage(rep, p.Feed, "prepare", 0, 1)
+	}
 
-		for _, p := range allRssPosts {
+	return drainQueue(ctx, db, c, binaryPath, rep)
+}
 
-			resp, err := http.Get(p.Url)
-			if err != nil {
-				log.Printf("could not get url %q: %s", p.Url, err)
-				continue
-			} else if resp == nil || resp.StatusCode != http.StatusOK {
-				continue
-			}
+// drainQueue publishes the due posts of the queue, as fast as the drip
+// limits of publish.json allow.
+func drainQueue(ctx context.Context, db *store.Store, c clients, binaryPath string, rep *report.Report) error {
+	scheduler, err := publish.LoadScheduler(db, path.Join(binaryPath, "publish.json"))
+	if err != nil {
+		return fmt.Errorf("could not LoadScheduler: %w", err)
+	}
 
-			p.Description, err = posts.PromptBetterSummarizeArticle(p.Title, p.Excerpt)
-			if err != nil {
-				log.Println(fmt.Errorf("could not promptBetterSummarizeArticle: %w", err))
-				continue
-			}
-			p.Title, err = posts.PromptBetterRephraseTitle(p.Title, "")
+	acceptedByFeed := make(map[string]int)
+	defer func() {
+		for feed, accepted := range acceptedByFeed {
+			err := addAccepted(db, feed, accepted)
 			if err != nil {
-				log.Println(fmt.Errorf("could not promptBetterRephraseTitle: %w", err))
-				continue
+				slog.Warn("could not addAccepted", "feed", feed, "error", err)
 			}
+		}
+	}()
 
-			err = aiapipro.NewPost(db, p, p.JWT)
-			if err != nil {
-				fmt.Println("could not NewPost", err)
-				continue
-			}
+	allow := func(item *queue.Item) bool {
+		ok, reason := scheduler.Allow(communityOf(item.CommunityID), clock.Now())
+		if !ok {
+			slog.Debug("held back", "feed", item.Feed, "item", posts.CanonicalURL(item.URL), "stage", "publish", "reason", reason)
+		}
+		return ok
+	}
+	_, err = queue.Drain(ctx, db, allow, func(p posts.Post) error {
+		err := c.lemmy.NewPost(db, p)
+		if errors.Is(err, aiapipro.ErrDuplicatePost) {
+			// The site has it already, never try it again
+			p.Logger("publish").Info("dropped, already on the site")
+			recordStage(rep, p.Feed, "publish", 1, 0)
+			return c.lemmy.MarkPosted(db, p)
+		}
+		if errors.Is(err, aiapipro.ErrBanned) || errors.Is(err, aiapipro.ErrCouldntCreatePost) {
+			recordStage(rep, p.Feed, "publish", 1, 0)
+			rep.Error("NewPost")
+			return fmt.Errorf("%w: %w", queue.ErrPermanent, err)
 		}
-	case "moderate":
-		feedConfigsJSON, err := os.ReadFile(path.Join(binaryPath, "moderate_rules.json"))
 		if err != nil {
-			log.Fatal("could not open 'moderate_rules.json", err)
+			recordStage(rep, p.Feed, "publish", 1, 0)
+			rep.Error("NewPost")
+			return err
+		}
+		recordStage(rep, p.Feed, "publish", 1, 1)
+		acceptedByFeed[p.Feed]++
+		if p.SourceText != "" {
+			err = posts.RecordExample(db, p.Url, p.SourceText, true)
+			if err != nil {
+				p.Logger("publish").Warn("could not RecordExample", "error", err)
+			}
 		}
-		moderateRules := ModerareRules{}
-		err = json.Unmarshal(feedConfigsJSON, &moderateRules)
+		err = scheduler.Record(communityOf(p.CommunityID), clock.Now())
 		if err != nil {
-			log.Fatal("could not unmarshal moderateRules:", err)
+			p.Logger("publish").Warn("could not record publish", "error", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not drain queue: %w", err)
+	}
+	return nil
+}
+
+// communityOf returns the community a post goes to, see NewPost.
+func communityOf(communityID int) int {
+	if communityID == 0 {
+		return aiapipro.DefaultCommunityID
+	}
+	return communityID
+}
+
+// addAccepted adds posted items to the health of the feed.
+func addAccepted(db *store.Store, feed string, accepted int) error {
+	health, err := rss.LoadHealth(db, feed)
+	if err != nil {
+		return err
+	}
+	health.Accepted += accepted
+	return health.Save(db)
+}
+
+// recordStage counts the items going in and out of a pipeline stage of a feed.
+func recordStage(rep *report.Report, feed, stage string, in, out int) {
+	rep.Stage(feed, stage, in, out)
+	metrics.Add("newsbots_stage_items_in_total", float64(in), "feed", feed, "stage", stage)
+	metrics.Add("newsbots_stage_items_out_total", float64(out), "feed", feed, "stage", stage)
+}
+
+// runModerate removes forbidden and duplicate posts and returns the IDs of
+// the removed posts.
+func runModerate(ctx context.Context, db *store.Store, c clients, binaryPath string, allCurrentPosts []aiapipro.Post) ([]int, error) {
+	feedConfigsJSON, err := os.ReadFile(path.Join(binaryPath, "moderate_rules.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open 'moderate_rules.json': %w", err)
+	}
+	moderateRules := ModerareRules{}
+	err = json.Unmarshal(feedConfigsJSON, &moderateRules)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal moderateRules: %w", err)
+	}
 
-		alreadyFoundTitle := make(map[string]bool, 0)
-		alreadyFoundUrl := make(map[string]bool, 0)
+	removed := make([]int, 0)
 
-		for k := len(allCurrentPosts) - 1; k >= 0; k-- {
-			p := allCurrentPosts[k]
-			if strings.TrimSpace(p.URL) == "" {
+	alreadyFoundTitle := make(map[string]bool, 0)
+	alreadyFoundUrl := make(map[string]bool, 0)
+
+	for k := len(allCurrentPosts) - 1; k >= 0; k-- {
+		if ctx.Err() != nil {
+			break
+		}
+		p := allCurrentPosts[k]
+		if strings.TrimSpace(p.URL) == "" {
+			continue
+		}
+
+		// Also check urls
+		delete := false
+		forbidden := false
+		reason := ""
+		for _, r := range moderateRules.ForbiddenUrlRegex {
+			if len(strings.ReplaceAll(r, " ", "_")) < 4 {
+				slog.Warn("skip too short url regex", "regex", r)
 				continue
 			}
 
-			// Also check urls
-			delete := false
-			for _, r := range moderateRules.ForbiddenUrlRegex {
+			if strings.Contains(strings.ToLower(p.URL), strings.ToLower(r)) {
+				slog.Info("delete because of url regex", "post_id", p.ID, "item", posts.CanonicalURL(p.URL), "regex", r)
+
+				delete = true
+				forbidden = true
+				reason = "url_rule"
+				break
+			}
+		}
+
+		if !delete {
+			// Check titles regex
+			for _, r := range moderateRules.ForbiddenTitleRegex {
 				if len(strings.ReplaceAll(r, " ", "_")) < 4 {
-					log.Println("Skip too short url regex", r)
+					slog.Warn("skip too short title regex", "regex", r)
 					continue
 				}
-
-				if strings.Contains(strings.ToLower(p.URL), strings.ToLower(r)) {
-					log.Println("Delete because of url regex", p.URL)
-
+				if strings.Contains(strings.ToLower(p.Name), strings.ToLower(r)) {
 					delete = true
+					forbidden = true
+					reason = "title_rule"
+					slog.Info("delete because of title regex", "post_id", p.ID, "item", posts.CanonicalURL(p.URL), "title", p.Name, "regex", r)
 					break
 				}
 			}
+		}
 
+		if !delete {
+			// Check if title matches. IF yes, delete
+			_, delete = alreadyFoundUrl[p.URL]
 			if !delete {
-				// Check titles regex
-				for _, r := range moderateRules.ForbiddenTitleRegex {
-					if len(strings.ReplaceAll(r, " ", "_")) < 4 {
-						log.Println("Skip too short title regex", r)
-						continue
-					}
-					if strings.Contains(strings.ToLower(p.Name), strings.ToLower(r)) {
-						delete = true
-						log.Println("Delete because of title regex", p.Name)
-						break
-					}
+				_, delete = alreadyFoundTitle[p.Name]
+				if delete {
+					reason = "duplicate_title"
+					slog.Info("delete because of already found title", "post_id", p.ID, "item", posts.CanonicalURL(p.URL), "title", p.Name)
 				}
+			} else {
+				reason = "duplicate_url"
+				slog.Info("delete because of already found url", "post_id", p.ID, "item", posts.CanonicalURL(p.URL))
 			}
 
-			if !delete {
-				// Check if title matches. IF yes, delete
-				_, delete = alreadyFoundUrl[p.URL]
-				if !delete {
-					_, delete = alreadyFoundTitle[p.Name]
-					if delete {
-						log.Println("Delete because of already found title", p.Name)
-					}
-				} else {
-					log.Println("Delete because of already found url", p.URL)
-				}
+		}
 
+		if delete {
+			// Delete the post
+			err = c.lemmy.DeletePost("moderator_bot", p.ID)
+			if err != nil {
+				slog.Error("could not delete post", "post_id", p.ID, "title", p.Name, "error", err)
+				continue
 			}
+			metrics.Add("newsbots_posts_removed_total", 1, "reason", reason)
+			removed = append(removed, p.ID)
 
-			if delete {
-				// Delete the post
-				jwt, err := aiapipro.LoginUser("moderator_bot")
+			// Removed as off-topic, learn from it. Duplicates say nothing about relevance
+			if forbidden {
+				err = posts.RelabelExample(db, p.URL, false)
 				if err != nil {
-					log.Println("could not login 'moderator_bot' post", err)
-					continue
+					slog.Warn("could not RelabelExample", "item", posts.CanonicalURL(p.URL), "error", err)
 				}
-				err = aiapipro.DeletePost(jwt, p.ID)
-				if err != nil {
-					log.Println("could not delete post", p.ID, p.Name, err)
-					continue
-				}
-
 			}
-			alreadyFoundTitle[p.Name] = true
-			alreadyFoundUrl[p.URL] = true
+
 		}
+		alreadyFoundTitle[p.Name] = true
+		alreadyFoundUrl[p.URL] = true
+	}
+	return removed, nil
+}
 
-	case "upvote":
-		fmt.Println("UPVOTE BOTS")
-		for i := 0; i < 4; i++ {
-			jwt, err := aiapipro.GetRandomAuthenticateUserToken(db)
-			if err != nil {
-				fmt.Println("could not GetRandomAuthenticateUserToken", err)
+func runUpvote(db *store.Store, c clients, allCurrentPosts []aiapipro.Post) {
+	slog.Info("upvote bots")
+	for i := 0; i < 4; i++ {
+		account, err := c.lemmy.RandomAccount()
+		if err != nil {
+			slog.Error("could not RandomAccount", "error", err)
+			continue
+		}
+
+		for k, _ := range allCurrentPosts {
+			post := allCurrentPosts[len(allCurrentPosts)-1-k]
+			if k > 30 {
+				break
+			}
+			// Only upvote with a 5% chance
+			if rand.Intn(100) > 5 {
+				//NO lucky, no upvote
 				continue
 			}
 
-			for k, _ := range allCurrentPosts {
-				post := allCurrentPosts[len(allCurrentPosts)-1-k]
-				if k > 30 {
-					break
-				}
-				// Only upvote with a 5% chance
-				if rand.Intn(100) > 5 {
-					//NO lucky, no upvote
-					continue
-				}
-
-				err := aiapipro.UpvotePost(post.ID, jwt)
-				if err != nil {
-					fmt.Println("could not UpvotePost ", post.ID, err)
-					continue
-				}
-				fmt.Println("UPVOTED", post.ID)
+			err := c.lemmy.UpvotePost(post.ID, account)
+			if err != nil {
+				slog.Error("could not UpvotePost", "post_id", post.ID, "error", err)
+				continue
 			}
+			slog.Info("upvoted", "post_id", post.ID)
+			metrics.Add("newsbots_upvotes_total", 1)
 		}
-	default:
-		log.Fatal("No valid command. Expect 'rss' or 'upvote'", os.Args[1])
 	}
 }
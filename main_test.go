@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"newsbots/pkg/aiapipro"
+	"newsbots/pkg/lemmytest"
+	"newsbots/pkg/report"
+	"newsbots/pkg/store"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEnv runs the commands against a lemmytest site, a fake PromptBetter
+// and a web server with a feed and its articles. The configs are in a temp
+// dir, which stands in for the binary path.
+type testEnv struct {
+	lemmy      *lemmytest.Server
+	web        *httptest.Server
+	llm        *fakeLLM
+	db         *store.Store
+	c          clients
+	binaryPath string
+}
+
+// articles of the test feed by path. The LLM rates articles about cooking
+// low, everything else high.
+var testArticles = []struct {
+	path  string
+	title string
+	text  string
+}{
+	{"/articles/llm", "New LLM tops the benchmark", "The new LLM tops every AI benchmark."},
+	{"/articles/pasta", "Pasta for beginners", "Boil the water and add salt."},
+	{"/articles/cooking", "AI in the kitchen", "An AI app suggests cooking recipes."},
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	e := &testEnv{
+		lemmy:      lemmytest.New(),
+		llm:        newFakeLLM(),
+		db:         store.OpenMemory(),
+		binaryPath: t.TempDir(),
+	}
+	t.Cleanup(e.lemmy.Close)
+	t.Cleanup(e.llm.Close)
+	t.Cleanup(func() { e.db.Close() })
+	e.lemmy.AddCommunity(4, "ai")
+	e.lemmy.Moderators = map[string]bool{"moderator_bot": true}
+
+	e.web = newTestWeb()
+	t.Cleanup(e.web.Close)
+
+	writeTestConfigs(t, e.binaryPath, e.web.URL+"/feed.xml", "ai_bot")
+	e.c = newTestClients(t, e.db, e.lemmy.URL, e.llm.URL)
+	for _, username := range []string{"ai_bot", "moderator_bot"} {
+		err := e.c.lemmy.EnsureAccount(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+// newTestWeb serves the feed with the test articles.
+func newTestWeb() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/rss+xml")
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Test</title>`)
+		for _, a := range testArticles {
+			fmt.Fprintf(w, "<item><title>%s</title><link>http://%s%s</link></item>", a.title, r.Host, a.path)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	})
+	for _, a := range testArticles {
+		a := a
+		mux.HandleFunc(a.path, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "<html><body><h1>%s</h1><p>%s</p></body></html>", a.title, a.text)
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+// writeTestConfigs writes the configs of one feed, posted by the user, to
+// the binary path.
+func writeTestConfigs(t *testing.T, binaryPath, feedURL, username string) {
+	t.Helper()
+	configs := map[string]interface{}{
+		"rss_feeds.json": []map[string]interface{}{{
+			"url":                feedURL,
+			"check_title":        false,
+			"check_link_content": true,
+			"username":           username,
+		}},
+		"ai_keywords.json": map[string]interface{}{
+			"keywords": []map[string]string{{"term": "AI"}, {"term": "LLM"}},
+		},
+		"community_rules.json": map[string]interface{}{"default_community_id": 4},
+		"publish.json":         map[string]interface{}{"per_hour": 100},
+		"moderate_rules.json": map[string]interface{}{
+			"forbidden_title_regex": []string{"Jobs"},
+			"forbidden_url_regex":   []string{"bloomberg.com"},
+		},
+	}
+	for name, config := range configs {
+		configJSON, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path.Join(binaryPath, name), configJSON, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestClients creates the clients like main, for the site and the LLM at
+// the urls.
+func newTestClients(t *testing.T, db *store.Store, lemmyURL, llmURL string) clients {
+	t.Helper()
+	t.Setenv("LEMMY_URL", lemmyURL)
+	t.Setenv("PROMPTBETTER_URL", llmURL)
+	c, err := newClients(db, map[string]string{
+		"ACCOUNTS_KEY":       strings.Repeat("ab", 32),
+		"PROMPTBETTER_TOKEN": "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (e *testEnv) currentPosts(t *testing.T) []aiapipro.Post {
+	t.Helper()
+	allCurrentPosts, err := loadCurrentPosts(e.db, e.c.lemmy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return allCurrentPosts
+}
+
+// requests counts the requests to the path by user.
+func (e *testEnv) requests(method, path string) map[string]int {
+	byUser := make(map[string]int)
+	for _, r := range e.lemmy.Requests() {
+		if r.Method == method && r.Path == path {
+			byUser[r.User]++
+		}
+	}
+	return byUser
+}
+
+// fakeLLM answers the PromptBetter prompts and counts the calls.
+type fakeLLM struct {
+	*httptest.Server
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+func newFakeLLM() *fakeLLM {
+	f := &fakeLLM{calls: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeLLM) handle(w http.ResponseWriter, r *http.Request) {
+	prompt := path.Base(r.URL.Path)
+	f.mutex.Lock()
+	f.calls[prompt]++
+	f.mutex.Unlock()
+
+	payload := map[string]string{}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	answer := ""
+	switch prompt {
+	case "check-if-post-is-about-ai":
+		answer = "8"
+		if strings.Contains(payload["article_text"], "cooking") {
+			answer = "3"
+		}
+	case "write-summary-of-website":
+		answer = "Summary of " + payload["title"]
+	case "rephrase-title":
+		answer = "Rephrased " + payload["title"]
+	case "classify-topic":
+		answer = "industry news"
+	default:
+		http.Error(w, "unknown prompt", http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"data": answer})
+}
+
+func (f *fakeLLM) Calls(prompt string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[prompt]
+}
+
+func TestRunRSS(t *testing.T) {
+	e := newTestEnv(t)
+
+	rep := report.New("test", "rss")
+	err := runRSS(context.Background(), e.db, e.c, e.binaryPath, e.currentPosts(t), rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sitePosts := e.lemmy.Posts()
+	if len(sitePosts) != 1 {
+		t.Fatalf("got %d posts, want 1: %+v", len(sitePosts), sitePosts)
+	}
+	p := sitePosts[0]
+	if p.URL != e.web.URL+"/articles/llm" {
+		t.Errorf("posted %s, want the LLM article", p.URL)
+	}
+	if p.Name != "Rephrased New LLM tops the benchmark" || p.Body != "Summary of New LLM tops the benchmark" {
+		t.Errorf("got title %q and body %q, want the LLM answers", p.Name, p.Body)
+	}
+	if p.CommunityID != 4 || p.CreatorID != e.lemmy.User("ai_bot").ID {
+		t.Errorf("got community %d by user %d, want 4 by ai_bot", p.CommunityID, p.CreatorID)
+	}
+	if created := e.requests("POST", "/api/v3/post"); created["ai_bot"] != 1 || len(created) != 1 {
+		t.Errorf("post requests %v, want one by ai_bot", created)
+	}
+	// The pasta article has no keyword, so only two articles are checked
+	if calls := e.llm.Calls("check-if-post-is-about-ai"); calls != 2 {
+		t.Errorf("%d article checks, want 2", calls)
+	}
+	verdict, err := e.db.Verdict(e.web.URL + "/articles/cooking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict == nil || verdict.Score != 3 {
+		t.Errorf("verdict %+v, want the rejection of the cooking article", verdict)
+	}
+
+	// The next run finds nothing new and asks the LLM nothing
+	err = runRSS(context.Background(), e.db, e.c, e.binaryPath, e.currentPosts(t), report.New("test", "rss"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.lemmy.Posts()) != 1 {
+		t.Errorf("got %d posts after the second run, want 1", len(e.lemmy.Posts()))
+	}
+	if calls := e.llm.Calls("check-if-post-is-about-ai"); calls != 2 {
+		t.Errorf("%d article checks after the second run, want 2", calls)
+	}
+}
+
+func TestRunModerate(t *testing.T) {
+	e := newTestEnv(t)
+	published := time.Now().Add(-time.Hour)
+	e.lemmy.AddPost("ai_bot", "Jobs in machine learning", "https://example.com/jobs", 4, published)
+	e.lemmy.AddPost("ai_bot", "GPT news", "https://example.com/gpt", 4, published)
+	e.lemmy.AddPost("ai_bot", "More GPT news", "https://example.com/gpt", 4, published)
+	e.lemmy.AddPost("ai_bot", "Markets and AI", "https://www.bloomberg.com/ai", 4, published)
+	e.lemmy.AddPost("ai_bot", "Claude news", "https://example.com/claude-old", 4, published)
+	e.lemmy.AddPost("ai_bot", "Claude news", "https://example.com/claude", 4, published)
+	e.lemmy.AddPost("ai_bot", "A fine post", "https://example.com/fine", 4, published)
+
+	removed, err := runModerate(context.Background(), e.db, e.c, e.binaryPath, e.currentPosts(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Of duplicates the newest post stays
+	sort.Ints(removed)
+	if fmt.Sprint(removed) != "[1 2 4 5]" {
+		t.Errorf("removed %v, want [1 2 4 5]", removed)
+	}
+	for _, p := range e.lemmy.Posts() {
+		wantRemoved := p.ID == 1 || p.ID == 2 || p.ID == 4 || p.ID == 5
+		if p.Removed != wantRemoved {
+			t.Errorf("post %d %q removed %v, want %v", p.ID, p.Name, p.Removed, wantRemoved)
+		}
+	}
+	if removes := e.requests("POST", "/api/v3/post/remove"); removes["moderator_bot"] != 4 || len(removes) != 1 {
+		t.Errorf("remove requests %v, want 4 by moderator_bot", removes)
+	}
+}
+
+func TestRunSitemap(t *testing.T) {
+	e := newTestEnv(t)
+	published := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	e.lemmy.AddPost("ai_bot", "First post", "https://example.com/1", 4, published)
+	e.lemmy.AddPost("ai_bot", "Second post", "https://example.com/2", 4, published.Add(24*time.Hour))
+	e.lemmy.AddPost("ai_bot", "Removed post", "https://example.com/3", 4, published).Removed = true
+
+	sitemapPath := path.Join(t.TempDir(), "sitemap.xml")
+	err := runSitemap(e.currentPosts(t), sitemapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sitemap, err := os.ReadFile(sitemapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		fmt.Sprintf("<loc>%s/post/1</loc>", e.lemmy.URL),
+		"<news:publication_date>2024-05-06</news:publication_date>",
+		"<news:title>First post</news:title>",
+		fmt.Sprintf("<loc>%s/post/2</loc>", e.lemmy.URL),
+		"<news:publication_date>2024-05-07</news:publication_date>",
+	} {
+		if !strings.Contains(string(sitemap), want) {
+			t.Errorf("sitemap has no %s:\n%s", want, sitemap)
+		}
+	}
+	if strings.Contains(string(sitemap), "Removed post") {
+		t.Errorf("sitemap has the removed post:\n%s", sitemap)
+	}
+	// The posts are read from the public list, without login
+	if lists := e.requests("GET", "/api/v3/post/list"); lists[""] == 0 || len(lists) != 1 {
+		t.Errorf("list requests %v, want only anonymous ones", lists)
+	}
+}
+
+func TestResetSeen(t *testing.T) {
+	e := newTestEnv(t)
+
+	// Without posts the site is likely down, nothing is dropped
+	err := e.db.MarkSeen("https://example.com/rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resetSeen(e.db, e.c)
+	if err == nil {
+		t.Errorf("reset with an empty site succeeded")
+	}
+	if seen, _ := e.db.Seen("https://example.com/rejected"); !seen {
+		t.Errorf("reset with an empty site dropped a seen url")
+	}
+
+	e.lemmy.AddPost("ai_bot", "Posted", "https://example.com/posted", 4, time.Now())
+	e.lemmy.AddPost("ai_bot", "New", "https://example.com/new", 4, time.Now())
+	e.lemmy.AddPost("ai_bot", "Removed", "https://example.com/removed", 4, time.Now()).Removed = true
+	err = e.db.MarkSeen("https://example.com/posted", "https://example.com/removed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = resetSeen(e.db, e.c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for url, want := range map[string]bool{
+		"https://example.com/posted":   true,
+		"https://example.com/new":      true,
+		"https://example.com/rejected": false,
+		"https://example.com/removed":  false,
+	} {
+		seen, err := e.db.Seen(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != want {
+			t.Errorf("Seen(%q) = %v, want %v", url, seen, want)
+		}
+	}
+}
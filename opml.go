@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"newsbots/pkg/opml"
+	"newsbots/pkg/store"
+	"os"
+)
+
+// importOPML adds the feeds of the OPML file to the db. Feeds which are
+// configured already are skipped.
+func importOPML(db *store.Store, binaryPath string, opmlPath string) error {
+	opmlFile, err := os.Open(opmlPath)
+	if err != nil {
+		return fmt.Errorf("could not open opml file: %w", err)
+	}
+	defer opmlFile.Close()
+	doc, err := opml.Read(opmlFile)
+	if err != nil {
+		return err
+	}
+
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+	configured := make(map[string]bool, len(feedConfigs))
+	for _, feedConfig := range feedConfigs {
+		configured[feedConfig.URL] = true
+	}
+
+	imported := 0
+	for _, outline := range doc.Feeds() {
+		if configured[outline.XMLURL] {
+			slog.Info("skipped, feed configured already", "feed", outline.XMLURL)
+			continue
+		}
+		feedConfig, err := outlineToFeedConfig(outline)
+		if err != nil {
+			return fmt.Errorf("could not import feed %q: %w", outline.XMLURL, err)
+		}
+		err = saveFeed(db, "import-opml", feedConfig.URL, &feedConfig)
+		if err != nil {
+			return err
+		}
+		configured[feedConfig.URL] = true
+		imported++
+	}
+
+	fmt.Printf("Imported %d feeds\n", imported)
+	return nil
+}
+
+// exportOPML writes all feed configs as OPML to the given file, or stdout.
+func exportOPML(db *store.Store, binaryPath string, opmlPath string) error {
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+
+	doc := opml.New("newsbots feeds")
+	for _, feedConfig := range feedConfigs {
+		doc.Body = append(doc.Body, feedConfigToOutline(feedConfig))
+	}
+
+	if opmlPath == "" {
+		return doc.Write(os.Stdout)
+	}
+	opmlFile, err := os.Create(opmlPath)
+	if err != nil {
+		return fmt.Errorf("could not create opml file: %w", err)
+	}
+	defer opmlFile.Close()
+	return doc.Write(opmlFile)
+}
+
+// feedConfigToOutline keeps the bot options as custom attributes, so an
+// export can be imported again without losing anything.
+func feedConfigToOutline(feedConfig RSSFeedConfig) opml.Outline {
+	outline := opml.Outline{
+		Text:   feedConfig.Username,
+		Title:  feedConfig.Username,
+		Type:   "rss",
+		XMLURL: feedConfig.URL,
+	}
+	for _, name := range feedOptionNames {
+		if value, ok := feedOption(feedConfig, name); ok {
+			outline.SetAttr(name, value)
+		}
+	}
+	return outline
+}
+
+// outlineToFeedConfig reads the bot options from the custom attributes.
+// Outlines from a feed reader have none, these get the checks enabled and a
+// username derived from the feed url.
+func outlineToFeedConfig(outline opml.Outline) (RSSFeedConfig, error) {
+	feedConfig := RSSFeedConfig{
+		URL:              outline.XMLURL,
+		CheckTitle:       true,
+		CheckLinkContent: true,
+		Username:         discoverUsername(outline.XMLURL),
+	}
+	for _, name := range feedOptionNames {
+		if value, ok := outline.Attr(name); ok {
+			err := setFeedOption(&feedConfig, name, value)
+			if err != nil {
+				return feedConfig, err
+			}
+		}
+	}
+	return feedConfig, nil
+}
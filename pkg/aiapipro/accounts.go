@@ -0,0 +1,398 @@
+package aiapipro
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/metrics"
+	"newsbots/pkg/store"
+	"time"
+)
+
+var credentials = store.Bucket[Credential]{Prefix: store.AccountPrefix}
+
+var ErrNoAccounts = errors.New("no account store, ACCOUNTS_KEY missing")
+
+// Credential is the stored login of a bot account. The passwords are
+// encrypted. PreviousPassword is only set while a password change is in
+// flight, so a crash in between does not lock the account out. Pending is
+// set until the registration went through, it is retried with the same
+// password.
+type Credential struct {
+	Username         string    `json:"username"`
+	Password         []byte    `json:"password"`
+	PreviousPassword []byte    `json:"previous_password,omitempty"`
+	Pending          bool      `json:"pending,omitempty"`
+	Created          time.Time `json:"created"`
+	Rotated          time.Time `json:"rotated,omitempty"`
+}
+
+// Accounts stores a random password per bot account, encrypted with
+// AES-GCM. Accounts without a stored password are from the time every
+// password was the username plus a shared suffix. They are migrated to a
+// random password on their next login.
+type Accounts struct {
+	db           *store.Store
+	aead         cipher.AEAD
+	legacySuffix string
+}
+
+func NewAccounts(db *store.Store, aead cipher.AEAD, legacySuffix string) *Accounts {
+	return &Accounts{
+		db:           db,
+		aead:         aead,
+		legacySuffix: legacySuffix,
+	}
+}
+
+// Known reports if the account was registered by the bots. Accounts from
+// before the store are only marked as legacy accounts. An account with a
+// pending registration is not known.
+func (a *Accounts) Known(username string) (bool, error) {
+	if a == nil {
+		return false, ErrNoAccounts
+	}
+	known := false
+	err := a.db.View(func(tx *store.Tx) error {
+		cred, err := credentials.Get(tx, username)
+		if err != nil || cred != nil {
+			known = cred != nil && !cred.Pending
+			return err
+		}
+		known, err = tx.LegacyAccount(username)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not get account from db: %w", err)
+	}
+	return known, nil
+}
+
+// Get returns the stored credential, or nil for a legacy account.
+func (a *Accounts) Get(username string) (*Credential, error) {
+	if a == nil {
+		return nil, ErrNoAccounts
+	}
+	cred, err := credentials.Load(a.db, username)
+	if err != nil {
+		return nil, fmt.Errorf("could not get account from db: %w", err)
+	}
+	return cred, nil
+}
+
+// List returns all stored credentials.
+func (a *Accounts) List() ([]Credential, error) {
+	if a == nil {
+		return nil, ErrNoAccounts
+	}
+	all, err := credentials.All(a.db)
+	if err != nil {
+		return nil, fmt.Errorf("could not list accounts: %w", err)
+	}
+	creds := make([]Credential, 0, len(all))
+	for _, cred := range all {
+		creds = append(creds, *cred)
+	}
+	return creds, nil
+}
+
+func (a *Accounts) save(cred *Credential) error {
+	return credentials.Save(a.db, cred.Username, cred)
+}
+
+// encrypt seals the password, bound to the username.
+func (a *Accounts) encrypt(username, password string) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not create nonce: %w", err)
+	}
+	return a.aead.Seal(nonce, nonce, []byte(password), []byte(username)), nil
+}
+
+func (a *Accounts) decrypt(username string, sealed []byte) (string, error) {
+	if len(sealed) < a.aead.NonceSize() {
+		return "", fmt.Errorf("encrypted password too short")
+	}
+	nonce, ciphertext := sealed[:a.aead.NonceSize()], sealed[a.aead.NonceSize():]
+	password, err := a.aead.Open(nil, nonce, ciphertext, []byte(username))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt password, wrong ACCOUNTS_KEY? %w", err)
+	}
+	return string(password), nil
+}
+
+// passwords returns the passwords to try for the account, the current one
+// first.
+func (a *Accounts) passwords(username string) ([]string, *Credential, error) {
+	cred, err := a.Get(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cred == nil {
+		return []string{username + a.legacySuffix}, nil, nil
+	}
+
+	passwords := make([]string, 0, 2)
+	for _, sealed := range [][]byte{cred.Password, cred.PreviousPassword} {
+		if len(sealed) == 0 {
+			continue
+		}
+		password, err := a.decrypt(username, sealed)
+		if err != nil {
+			return nil, nil, err
+		}
+		passwords = append(passwords, password)
+	}
+	return passwords, cred, nil
+}
+
+// NewPassword returns a random password.
+func NewPassword() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not read random: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// register creates the account with a random password. The password is
+// stored as pending before the account is created, so it is never lost. A
+// pending registration is retried with its stored password, the instance
+// may have created the account even if the request failed.
+func (c *Client) register(username string) (jwt string, err error) {
+	if c.accounts == nil {
+		return "", ErrNoAccounts
+	}
+	cred, err := c.accounts.Get(username)
+	if err != nil {
+		return "", err
+	}
+	password := ""
+	if cred != nil && cred.Pending {
+		password, err = c.accounts.decrypt(username, cred.Password)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		password, err = NewPassword()
+		if err != nil {
+			return "", err
+		}
+		sealed, err := c.accounts.encrypt(username, password)
+		if err != nil {
+			return "", err
+		}
+		cred = &Credential{
+			Username: username,
+			Password: sealed,
+			Pending:  true,
+			Created:  clock.Now().UTC(),
+		}
+		err = c.accounts.save(cred)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	jwt, err = c.createNewUser(username, password)
+	if err != nil {
+		return "", fmt.Errorf("could not createNewUser: %w", err)
+	}
+	cred.Pending = false
+	err = c.accounts.save(cred)
+	if err != nil {
+		return "", err
+	}
+	c.sessionsMutex.Lock()
+	c.storeSession(username, jwt, clock.Now())
+	c.sessionsMutex.Unlock()
+	return jwt, nil
+}
+
+type loginUserRequest struct {
+	UsernameOrEmail string `json:"username_or_email"`
+	Password        string `json:"password"`
+}
+
+// LoginUser logs in the account with its stored password. Legacy accounts
+// are moved to a random password after the login. Use Session to reuse the
+// login.
+func (c *Client) LoginUser(username string) (jwt string, err error) {
+	if c.accounts == nil {
+		return "", ErrNoAccounts
+	}
+	passwords, cred, err := c.accounts.passwords(username)
+	if err != nil {
+		return "", err
+	}
+
+	for k, password := range passwords {
+		jwt, err = c.login(username, password)
+		if errors.Is(err, ErrIncorrectLogin) {
+			// Try the previous password
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not login: %w", err)
+		}
+		if cred == nil {
+			return c.migrate(username, password, jwt), nil
+		}
+		if k > 0 || len(cred.PreviousPassword) > 0 || cred.Pending {
+			// Finish the interrupted password change or registration with
+			// the password which worked
+			sealed, err := c.accounts.encrypt(username, password)
+			if err == nil {
+				cred.Password = sealed
+				cred.PreviousPassword = nil
+				cred.Pending = false
+				err = c.accounts.save(cred)
+			}
+			if err != nil {
+				slog.Warn("could not save account", "user", username, "error", err)
+			}
+		}
+		return jwt, nil
+	}
+	if cred != nil && cred.Pending && errors.Is(err, ErrIncorrectLogin) {
+		// The account was never created, register it again
+		jwt, err = c.register(username)
+		if err != nil {
+			return "", fmt.Errorf("could not register: %w", err)
+		}
+		return jwt, nil
+	}
+	return "", err
+}
+
+// migrate gives a legacy account a random password and returns the valid
+// JWT. A failed migration is only logged, the account keeps working with
+// the legacy password.
+func (c *Client) migrate(username, legacyPassword, jwt string) string {
+	newJWT, err := c.changePassword(username, legacyPassword, jwt, nil)
+	if err != nil {
+		slog.Warn("could not migrate account to a random password", "user", username, "error", err)
+		return jwt
+	}
+	slog.Info("migrated account to a random password", "user", username)
+	return newJWT
+}
+
+// RotatePassword gives the account a new random password.
+func (c *Client) RotatePassword(username string) error {
+	if c.accounts == nil {
+		return ErrNoAccounts
+	}
+	// No login of the account may run into the change
+	lock := c.accountLock(username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	jwt, err := c.LoginUser(username)
+	if err != nil {
+		return fmt.Errorf("could not LoginUser: %w", err)
+	}
+	// The login may have migrated or repaired the credential
+	passwords, cred, err := c.accounts.passwords(username)
+	if err != nil {
+		return err
+	}
+	newJWT, err := c.changePassword(username, passwords[0], jwt, cred)
+	if err != nil {
+		return err
+	}
+	// The old sessions ended with the change
+	c.sessionsMutex.Lock()
+	c.storeSession(username, newJWT, clock.Now())
+	c.sessionsMutex.Unlock()
+	return nil
+}
+
+type changePasswordRequest struct {
+	NewPassword       string `json:"new_password"`
+	NewPasswordVerify string `json:"new_password_verify"`
+	OldPassword       string `json:"old_password"`
+	Auth              string `json:"auth,omitempty"`
+}
+
+// changePassword sets a new random password. The new password is stored
+// with the old one as fallback before the change, and the fallback is
+// dropped after it. previous is the stored credential, nil for a legacy
+// account. The change ends all sessions, the new JWT is returned.
+func (c *Client) changePassword(username, oldPassword, jwt string, previous *Credential) (newJWT string, err error) {
+	newPassword, err := NewPassword()
+	if err != nil {
+		return "", err
+	}
+	sealedNew, err := c.accounts.encrypt(username, newPassword)
+	if err != nil {
+		return "", err
+	}
+	sealedOld, err := c.accounts.encrypt(username, oldPassword)
+	if err != nil {
+		return "", err
+	}
+	now := clock.Now().UTC()
+	cred := &Credential{
+		Username:         username,
+		Password:         sealedNew,
+		PreviousPassword: sealedOld,
+		Created:          now,
+		Rotated:          now,
+	}
+	lastRotated := time.Time{}
+	if previous != nil {
+		cred.Created = previous.Created
+		lastRotated = previous.Rotated
+	}
+	err = c.accounts.save(cred)
+	if err != nil {
+		return "", err
+	}
+
+	changeReq := changePasswordRequest{
+		NewPassword:       newPassword,
+		NewPasswordVerify: newPassword,
+		OldPassword:       oldPassword,
+		Auth:              c.bodyAuth(jwt),
+	}
+	changeResp := createNewUserResponse{}
+	err = c.do("PUT", "/api/v3/user/change_password", jwt, &changeReq, &changeResp)
+	if err != nil {
+		// Keep the old password
+		cred.Password = sealedOld
+		cred.PreviousPassword = nil
+		cred.Rotated = lastRotated
+		if saveErr := c.accounts.save(cred); saveErr != nil {
+			slog.Warn("could not save account", "user", username, "error", saveErr)
+		}
+		return "", err
+	}
+
+	cred.PreviousPassword = nil
+	err = c.accounts.save(cred)
+	if err != nil {
+		return "", err
+	}
+	return changeResp.JWT, nil
+}
+
+func (c *Client) login(username, password string) (jwt string, err error) {
+	loginUser := loginUserRequest{
+		UsernameOrEmail: username,
+		Password:        password,
+	}
+	loginResp := createNewUserResponse{}
+	err = c.do("POST", "/api/v3/user/login", "", &loginUser, &loginResp)
+	if err != nil {
+		return "", err
+	}
+	metrics.Add("newsbots_logins_total", 1)
+	return loginResp.JWT, nil
+}
@@ -0,0 +1,95 @@
+package aiapipro
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"net/http"
+	"newsbots/pkg/lemmytest"
+	"newsbots/pkg/store"
+	"testing"
+)
+
+func newTestAccounts(t *testing.T) *Accounts {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.OpenMemory()
+	t.Cleanup(func() { db.Close() })
+	return NewAccounts(db, aead, "-legacy")
+}
+
+func TestRegisterRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		recover func(c *Client) error
+	}{
+		{"EnsureAccount", func(c *Client) error {
+			return c.EnsureAccount("bot")
+		}},
+		{"Session", func(c *Client) error {
+			_, err := c.Session("bot")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lemmy := lemmytest.New()
+			defer lemmy.Close()
+			c := NewClient(newTestAccounts(t))
+			c.BaseURL = lemmy.URL
+
+			lemmy.Fail("/api/v3/user/register", http.StatusBadGateway, "", 1)
+			err := c.EnsureAccount("bot")
+			if err == nil {
+				t.Fatalf("registration succeeded despite the failure")
+			}
+			pending, err := c.accounts.Get("bot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pending == nil || !pending.Pending {
+				t.Fatalf("credential %+v, want a pending one", pending)
+			}
+			known, err := c.accounts.Known("bot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if known {
+				t.Errorf("pending account is known")
+			}
+
+			err = tt.recover(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cred, err := c.accounts.Get("bot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cred.Pending {
+				t.Errorf("credential still pending after the registration")
+			}
+			password, err := c.accounts.decrypt("bot", cred.Password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := lemmy.User("bot")
+			if user == nil || user.Password != password {
+				t.Fatalf("registered user %+v, want the stored password", user)
+			}
+			stored, err := c.accounts.decrypt("bot", pending.Password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != password {
+				t.Errorf("registration retried with a new password")
+			}
+		})
+	}
+}
@@ -1,99 +1,59 @@
 package aiapipro
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"math/rand"
-	"net/http"
 	"net/url"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/metrics"
 	"newsbots/pkg/posts"
-	"os"
+	"newsbots/pkg/queue"
+	"newsbots/pkg/store"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
-
-	badger "github.com/dgraph-io/badger/v4"
 )
 
 var usernames = []string{"Vernon", "Bevan", "Jacinta", "Habib", "Michel", "Luther", "Josslyn", "Otho", "Safiya", "Roxie", "Sarra", "Jayse", "Tully", "Sephora", "Kenza", "Nosson", "Sadee", "Hagen", "Anitra", "Willma", "Blanchard", "Malia", "Baron", "Neo", "Viviann", "Haydon", "Catherine", "Thalia", "Titan", "Kenya", "Harlin", "Ayden", "Kasandra", "Saxon", "Ulisses", "Zach", "Aly", "Henna", "Romana", "Rowan", "Carmela", "Remi", "Peter", "Aman", "Jocelynne", "Flo", "Clifton", "Scot", "Gerry", "Keyton", "Hong", "Quint", "Cheron", "Katelynn", "Kaven", "Elsworth", "Jenelle", "Fernando", "Vilas", "Susette", "Meda", "Windsor", "Karine", "Kamela", "Kristeen", "Kairi", "Saloni", "Janice", "Abel", "Christin", "Stewart", "Guilherme", "Marylu", "Reymundo", "Anton", "Kaleena", "Florida", "Quinten", "Zoi", "Eleni", "Gia", "Selmer", "Reuben", "Zaynab", "Justen", "Emi", "Filip", "Sherry", "Wendie", "Vannie", "Deron", "Nicklaus", "Hamilton", "Rebekah", "Sabas", "Pixie", "Belinda", "Estel", "Glenda", "Darnell", "Mart", "Takumi", "Ezell", "Emanuel", "Nabor", "Abdulaziz", "Josh", "Owen", "Noor", "Andriana", "Sesar", "Celestia", "Giovana", "Kamila", "Vana", "Marja", "Nihal", "Aedan", "Gabrielle", "Berlin", "Jaxson", "Diangelo", "Zachari", "Wendi", "Ayelet", "Oren", "Clarisa", "Theola", "Heidy", "Abella", "Jude", "Zaden", "Salley", "Marcelino", "Cesario", "Marcia", "Phelan", "Sherrell", "Pascale", "Stephane", "Kelvin", "Marilu", "Edwina", "Florentino"}
 
-var passwordSuffix = os.Getenv("PASSWORD_SUFFIX")
-
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func GetRandomAuthenticateUserToken(db *badger.DB) (token string, err error) {
+// RandomAccount returns a random bot account with a session. The account is
+// registered first if it does not exist yet.
+func (c *Client) RandomAccount() (username string, err error) {
 	// Get a random username
 	randomUsername := strings.ToLower(usernames[rand.Intn(len(usernames))])
 
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
-
-	_, err = txn.Get([]byte(randomUsername))
-	if err == nil {
-		// Key found, return it
-		log.Print("Key found, user exists. Login")
-		return LoginUser(randomUsername)
-	}
-	if !errors.Is(err, badger.ErrKeyNotFound) {
-		return "", fmt.Errorf("could not get from db: %w", err)
-	}
-	err = txn.Set([]byte(randomUsername), []byte(token))
+	err = c.EnsureAccount(randomUsername)
 	if err != nil {
-		return "", fmt.Errorf("could not set to db: %w", err)
-	}
-
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		return "", fmt.Errorf("could not commit to db: %w", err)
+		slog.Warn("could not register, try to login", "user", randomUsername, "error", err)
 	}
-
-	// Key not found, create new user
-	jwt, err := createNewUser(randomUsername)
+	_, err = c.Session(randomUsername)
 	if err != nil {
-		return LoginUser(randomUsername)
+		return "", err
 	}
-	token = jwt
-	return token, nil
-
+	return randomUsername, nil
 }
 
-func GetAuthenticateUserToken(db *badger.DB, username string) (token string, err error) {
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
-
-	_, err = txn.Get([]byte(username))
-	if err == nil {
-		// Key found, return it
-		return LoginUser(username)
-	}
-	if !errors.Is(err, badger.ErrKeyNotFound) {
-		return "", fmt.Errorf("could not get from db: %w", err)
-	}
-
-	err = txn.Set([]byte(username), []byte(token))
+// EnsureAccount registers the account if it does not exist yet.
+func (c *Client) EnsureAccount(username string) error {
+	known, err := c.accounts.Known(username)
 	if err != nil {
-		return "", fmt.Errorf("could not set to db: %w", err)
+		return err
 	}
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		return "", fmt.Errorf("could not commit to db: %w", err)
+	if known {
+		return nil
 	}
 
-	// Key not found, create new user
-	jwt, err := createNewUser(username)
+	_, err = c.register(username)
 	if err != nil {
-		return "", fmt.Errorf("could not createNewUser: %w", err)
+		return fmt.Errorf("could not register: %w", err)
 	}
-	token = jwt
-
-	return token, nil
-
+	return nil
 }
 
 type createNewUserResponse struct {
@@ -111,6 +71,8 @@ type getPostsResponse struct {
 		Post   Post   `json:"post"`
 		Counts Counts `json:"counts"`
 	} `json:"posts"`
+	// NextPage is the cursor of the next page since Lemmy 0.19
+	NextPage string `json:"next_page"`
 }
 
 type Counts struct {
@@ -125,15 +87,17 @@ type Counts struct {
 	NewestCommentTime      string `json:"newest_comment_time"`
 	FeaturedCommunity      bool   `json:"featured_community"`
 	FeaturedLocal          bool   `json:"featured_local"`
-	HotRank                int    `json:"hot_rank"`
-	HotRankActive          int    `json:"hot_rank_active"`
-	CommunityId            int    `json:"community_id"`
-	CreatorId              int    `json:"creator_id"`
+	// Lemmy 0.19 sends fractional hot ranks
+	HotRank       float64 `json:"hot_rank"`
+	HotRankActive float64 `json:"hot_rank_active"`
+	CommunityId   int     `json:"community_id"`
+	CreatorId     int     `json:"creator_id"`
 }
 type Post struct {
 	ID                int    `json:"id"`
 	Name              string `json:"name"`
 	URL               string `json:"url"`
+	Body              string `json:"body"`
 	CreatorID         int    `json:"creator_id"`
 	CommunityID       int    `json:"community_id"`
 	Removed           bool   `json:"removed"`
@@ -152,24 +116,61 @@ type Post struct {
 	Counts            Counts `json:"-"`
 }
 
-func GetPosts() ([]Post, error) {
+// GetPosts returns all posts of the site, oldest first.
+func (c *Client) GetPosts() ([]Post, error) {
+	return c.listPosts("", 0)
+}
+
+// GetNewPosts returns the posts newer than the post ID, oldest first. It
+// only pages back until the post.
+func (c *Client) GetNewPosts(sinceID int) ([]Post, error) {
+	return c.listPosts("New", sinceID)
+}
+
+// listPosts pages through the posts of the site. With a sinceID, the posts
+// come newest first and paging stops at the first older post.
+func (c *Client) listPosts(sortType string, sinceID int) ([]Post, error) {
 	respPosts := make([]Post, 0)
 
+	// Lemmy 0.19 pages with a cursor, older versions with page numbers
+	v019 := c.v019()
+	cursor := ""
 	for page := 1; ; page++ {
+		query := fmt.Sprintf("limit=50&page=%d", page)
+		if cursor != "" {
+			query = "limit=50&page_cursor=" + url.QueryEscape(cursor)
+		}
+		if sortType != "" {
+			query += "&sort=" + sortType
+		}
 		resp := getPostsResponse{}
-		err := posts.GetJSON(fmt.Sprintf("https://news.aiapipro.com/api/v3/post/list?limit=50&page=%d", page), &resp)
+		err := c.do("GET", "/api/v3/post/list?"+query, "", nil, &resp)
 		if err != nil {
-			return nil, fmt.Errorf("could not GetJSON: %w", err)
+			return nil, fmt.Errorf("could not get posts: %w", err)
 		}
 		if len(resp.Posts) == 0 {
 			break
 		}
+		reachedSince := false
 		for _, p := range resp.Posts {
+			if sinceID > 0 && p.Post.ID <= sinceID {
+				reachedSince = true
+				continue
+			}
 			newPost := p.Post
 			newPost.Counts = p.Counts
 			newPost.URL = strings.TrimPrefix(newPost.URL, "https://reader.aiapipro.com/?url=")
 			respPosts = append(respPosts, newPost)
 		}
+		if reachedSince {
+			break
+		}
+		if v019 {
+			if resp.NextPage == "" {
+				break
+			}
+			cursor = resp.NextPage
+		}
 	}
 
 	sort.Slice(respPosts, func(i, j int) bool {
@@ -182,119 +183,49 @@ func GetPosts() ([]Post, error) {
 type deletePostsResponsRequest struct {
 	PostID  int    `json:"post_id"`
 	Removed bool   `json:"removed"`
-	Auth    string `json:"auth"`
+	Auth    string `json:"auth,omitempty"`
 }
 
-func DeletePost(jwt string, postID int) error {
-	req := deletePostsResponsRequest{
-		PostID:  postID,
-		Removed: true,
-		Auth:    jwt,
-	}
-	return posts.PostJSON("https://news.aiapipro.com/api/v3/post/remove", &req, nil)
+// DeletePost removes the post as the given moderator account.
+func (c *Client) DeletePost(username string, postID int) error {
+	return c.withSession(username, func(jwt string) error {
+		req := deletePostsResponsRequest{
+			PostID:  postID,
+			Removed: true,
+			Auth:    c.bodyAuth(jwt),
+		}
+		return c.do("POST", "/api/v3/post/remove", jwt, &req, nil)
+	})
 }
 
 type upvotePostRequest struct {
 	PostID int    `json:"post_id"`
 	Score  int    `json:"score"`
-	Auth   string `json:"auth"`
+	Auth   string `json:"auth,omitempty"`
 }
 
-func UpvotePost(postID int, jwt string) (err error) {
-	upvoteReq := upvotePostRequest{
-		PostID: postID,
-		Score:  1,
-		Auth:   jwt,
-	}
-	upvoteReqJSON, err := json.Marshal(upvoteReq)
-	if err != nil {
-		return fmt.Errorf("could not marshal request body: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://news.aiapipro.com/api/v3/post/like", bytes.NewReader(upvoteReqJSON))
-	if err != nil {
-		return fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("content-type", "application/json")
-	_, err = http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("could not do request: %w", err)
-	}
-	return nil
+func (c *Client) UpvotePost(postID int, username string) (err error) {
+	return c.withSession(username, func(jwt string) error {
+		upvoteReq := upvotePostRequest{
+			PostID: postID,
+			Score:  1,
+			Auth:   c.bodyAuth(jwt),
+		}
+		return c.do("POST", "/api/v3/post/like", jwt, &upvoteReq, nil)
+	})
 }
 
-func createNewUser(username string) (jwt string, err error) {
+func (c *Client) createNewUser(username, password string) (jwt string, err error) {
 	newUser := createNewUserRequest{
 		Username:       username,
-		Password:       username + passwordSuffix,
-		PasswordVerify: username + passwordSuffix,
+		Password:       password,
+		PasswordVerify: password,
 		ShowNSFW:       false,
 	}
-	newUserJSON, err := json.Marshal(newUser)
-	if err != nil {
-		return "", fmt.Errorf("could not marshal request body: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://news.aiapipro.com/api/v3/user/register", bytes.NewReader(newUserJSON))
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("content-type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("could not do request: %w", err)
-	}
-	defer resp.Body.Close()
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("could not read response body: %w", err)
-	}
-
-	newUserResp := createNewUserResponse{}
-	err = json.Unmarshal(bodyText, &newUserResp)
-	if err != nil {
-		return "", fmt.Errorf("could not unmarshal response body: %w", err)
-	}
-	return newUserResp.JWT, nil
-}
-
-type loginUserRequest struct {
-	UsernameOrEmail string `json:"username_or_email"`
-	Password        string `json:"password"`
-}
-
-func LoginUser(username string) (jwt string, err error) {
-	loginUser := loginUserRequest{
-		UsernameOrEmail: username,
-		Password:        username + passwordSuffix,
-	}
-	loginUserJSON, err := json.Marshal(loginUser)
-	if err != nil {
-		return "", fmt.Errorf("could not marshal request body: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://news.aiapipro.com/api/v3/user/login", bytes.NewReader(loginUserJSON))
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("content-type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("could not do request: %w", err)
-	}
-	defer resp.Body.Close()
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("could not read response body: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Did not get status 200, but %d. Body: %s", resp.StatusCode, string(bodyText))
-	}
-
 	newUserResp := createNewUserResponse{}
-	err = json.Unmarshal(bodyText, &newUserResp)
+	err = c.do("POST", "/api/v3/user/register", "", &newUser, &newUserResp)
 	if err != nil {
-		return "", fmt.Errorf("could not unmarshal response body: %w", err)
+		return "", err
 	}
 	return newUserResp.JWT, nil
 }
@@ -302,99 +233,100 @@ func LoginUser(username string) (jwt string, err error) {
 type newPostRequest struct {
 	Name        string `json:"name"`
 	URL         string `json:"url"`
-	Auth        string `json:"auth"`
+	Auth        string `json:"auth,omitempty"`
 	CommunityID int    `json:"community_id"`
 	Body        string `json:"body,omitempty"`
 }
 
-func NewPost(db *badger.DB, post posts.Post, jwt string) (err error) {
+// NewPost publishes the post as the account of the post.
+func (c *Client) NewPost(db *store.Store, post posts.Post) (err error) {
 	newPost := newPostRequest{
 		Name:        post.Title,
 		URL:         post.Url,
-		Auth:        jwt,
-		CommunityID: 4,
+		CommunityID: post.CommunityID,
 		Body:        post.Description,
 	}
-	if strings.Contains(post.Url, "paperswithcode.com") || strings.Contains(post.Url, "arxiv.org") {
-		newPost.CommunityID = 7 // Set to papers community
-	}
-
-	newPostJSON, err := json.Marshal(newPost)
-	if err != nil {
-		return fmt.Errorf("could not marshal request body: %w", err)
+	if newPost.CommunityID == 0 {
+		newPost.CommunityID = DefaultCommunityID
 	}
 
-	req, err := http.NewRequest("POST", "https://news.aiapipro.com/api/v3/post", bytes.NewReader(newPostJSON))
-	if err != nil {
-		return fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("content-type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	err = c.withSession(post.Account, func(jwt string) error {
+		newPost.Auth = c.bodyAuth(jwt)
+		return c.do("POST", "/api/v3/post", jwt, &newPost, nil)
+	})
 	if err != nil {
-		return fmt.Errorf("could not do request: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("status returned not 200 != %d. Body: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("could not create post: %w", err)
 	}
 
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
-
-	key := "post+" + post.Url
-
-	err = txn.Set([]byte(key), []byte(post.Url))
+	err = c.MarkPosted(db, post)
 	if err != nil {
-		return fmt.Errorf("could not set to db: %w", err)
+		return err
 	}
 
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		return fmt.Errorf("could not commit to db: %w", err)
-	}
-
-	fmt.Println("POSTED", post.Title)
+	post.Logger("publish").Info("posted", "title", post.Title, "community", newPost.CommunityID)
+	metrics.Add("newsbots_posts_created_total", 1, "feed", post.Feed, "community", strconv.Itoa(newPost.CommunityID))
 
 	return nil
 }
 
-func FilterAlreadyPosted(db *badger.DB, rssPosts posts.Posts) (posts.Posts, error) {
+// MarkPosted records the post as posted, so it is filtered out from now on.
+func (c *Client) MarkPosted(db *store.Store, post posts.Post) error {
+	return db.MarkSeen(post.Url)
+}
+
+func FilterAlreadyPosted(db *store.Store, rssPosts posts.Posts) (posts.Posts, error) {
 	allCurrentUrls := make(map[string]bool, 0)
 	notPosted := make(posts.Posts, 0, len(rssPosts))
 	for _, p := range rssPosts {
-		key := "post+" + p.Url
-
 		if _, posted := allCurrentUrls[p.Url]; posted {
 			// Found in current page. Filter out
+			p.Logger("already_posted").Info("dropped, duplicate in feed")
 			continue
 		}
 
-		txn := db.NewTransaction(true)
-		defer txn.Discard()
-		_, err := txn.Get([]byte(key))
-		if err == nil {
+		posted, err := db.Seen(p.Url)
+		if err != nil {
+			return nil, err
+		}
+		if posted {
 			// Key found, filter out
+			p.Logger("already_posted").Info("dropped, already posted")
+			continue
+		}
+
+		// Prepared posts wait in the queue, with the reader url if the feed
+		// uses the reader
+		queued := false
+		for _, u := range []string{p.Url, "https://reader.aiapipro.com/?url=" + p.Url} {
+			found, err := queue.Contains(db, u)
+			if err != nil {
+				return nil, err
+			}
+			queued = queued || found
+		}
+		if queued {
+			p.Logger("already_posted").Info("dropped, waiting in queue")
 			continue
 		}
 
 		notPosted = append(notPosted, p)
 	}
 
-	log.Printf("Filtered out %d in 'FilterAlreadyPosted'", len(rssPosts)-len(notPosted))
+	slog.Info("filtered", "stage", "already_posted", "dropped", len(rssPosts)-len(notPosted))
 
 	return notPosted, nil
 
 }
 
-func FilterTooMuchPosted(db *badger.DB, max int, rssPosts posts.Posts, allCurrentPosts []Post) (posts.Posts, error) {
-	todayDate := time.Now().Format("2006-01-02")
+func FilterTooMuchPosted(db *store.Store, max int, rssPosts posts.Posts, allCurrentPosts []Post) (posts.Posts, error) {
+	todayDate := clock.Now().Format("2006-01-02")
 
 	notPosted := make(posts.Posts, 0, len(rssPosts))
 
 	for _, p := range rssPosts {
 		postUrl, err := url.Parse(p.Url)
 		if err != nil {
-			log.Print("could not parse url:", p.Url, err)
+			p.Logger("too_much_posted").Warn("could not parse url", "error", err)
 			continue
 		}
 
@@ -409,13 +341,14 @@ func FilterTooMuchPosted(db *badger.DB, max int, rssPosts posts.Posts, allCurren
 			}
 		}
 		if alreadyPosts >= max {
+			p.Logger("too_much_posted").Info("dropped, host posted too often today", "host", postUrl.Host, "posts_today", alreadyPosts)
 			continue
 		}
 
 		notPosted = append(notPosted, p)
 	}
 
-	log.Printf("Filtered out %d in 'FilterTooMuchPosted'", len(rssPosts)-len(notPosted))
+	slog.Info("filtered", "stage", "too_much_posted", "dropped", len(rssPosts)-len(notPosted))
 
 	return notPosted, nil
 
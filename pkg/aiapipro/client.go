@@ -0,0 +1,113 @@
+package aiapipro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"newsbots/pkg/metrics"
+	"sync"
+	"time"
+)
+
+const BaseURL = "https://news.aiapipro.com"
+
+// Client talks to the Lemmy API of the site. The passwords of the bot
+// accounts come from the account store, their sessions are cached.
+type Client struct {
+	BaseURL  string
+	accounts *Accounts
+
+	// sessionsMutex guards the maps only, the logins of an account are
+	// serialized by its lock in accountLocks
+	sessionsMutex sync.Mutex
+	sessions      map[string]session
+	accountLocks  map[string]*sync.Mutex
+
+	limiter  limiter
+	siteInfo siteInfo
+}
+
+// NewClient creates a client. Without accounts only the public API works.
+func NewClient(accounts *Accounts) *Client {
+	return &Client{
+		BaseURL:      BaseURL,
+		accounts:     accounts,
+		sessions:     make(map[string]session),
+		accountLocks: make(map[string]*sync.Mutex),
+	}
+}
+
+func (c *Client) Accounts() *Accounts {
+	return c.accounts
+}
+
+// do sends the request within the rate limit of its endpoint class, and
+// retries with backoff when the instance limits it anyway. The jwt, if not
+// empty, authenticates the request.
+func (c *Client) do(method, path, jwt string, in, out interface{}) error {
+	class := endpointClass(method, path)
+	for attempt := 0; ; attempt++ {
+		c.wait(class)
+		err := c.send(method, path, jwt, in, out)
+		if !errors.Is(err, ErrRateLimited) || attempt >= RateLimitRetries {
+			return err
+		}
+		c.drain(class)
+		wait := retryAfter(err, attempt)
+		slog.Warn("rate limited by instance, retry", "class", class, "path", path, "wait", wait, "attempt", attempt+1)
+		metrics.Add("newsbots_rate_limited_total", 1, "class", class)
+		time.Sleep(wait)
+	}
+}
+
+// send sends in as JSON body, if not nil, and decodes the response into out,
+// if not nil.
+func (c *Client) send(method, path, jwt string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		inJSON, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not marshal request body: %w", err)
+		}
+		body = bytes.NewReader(inJSON)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if jwt != "" {
+		// Lemmy 0.19 reads the header or the cookie, older versions the
+		// auth field of the body
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		req.AddCookie(&http.Cookie{Name: "jwt", Value: jwt})
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp, respBody)
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.Unmarshal(respBody, out)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal response body: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package aiapipro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Errors of the Lemmy API, match them with errors.Is.
+var (
+	ErrRateLimited       = errors.New("rate limited")
+	ErrNotLoggedIn       = errors.New("not logged in")
+	ErrIncorrectLogin    = errors.New("incorrect login")
+	ErrDuplicatePost     = errors.New("duplicate post")
+	ErrCouldntCreatePost = errors.New("could not create post")
+	ErrBanned            = errors.New("banned")
+)
+
+// errorCodes maps the error codes of Lemmy to the errors above.
+var errorCodes = map[string]error{
+	"rate_limit_error":                    ErrRateLimited,
+	"not_logged_in":                       ErrNotLoggedIn,
+	"incorrect_login":                     ErrIncorrectLogin,
+	"couldnt_find_that_username_or_email": ErrIncorrectLogin,
+	"post_already_exists":                 ErrDuplicatePost,
+	"duplicate_post":                      ErrDuplicatePost,
+	"couldnt_create_post":                 ErrCouldntCreatePost,
+	"site_ban":                            ErrBanned,
+	"banned_from_community":               ErrBanned,
+	"person_is_banned_from_site":          ErrBanned,
+	"person_is_banned_from_community":     ErrBanned,
+}
+
+// APIError is a response of the API with a status other than 200. Code is
+// the error of Lemmy's {"error": ...} payload, if any.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Body       string
+	// RetryAfter is the Retry-After header, if any
+	RetryAfter string
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: resp.Header.Get("Retry-After"),
+	}
+	payload := struct {
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(body, &payload) == nil {
+		apiErr.Code = payload.Error
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("status %d: %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("status not 200, but %d. Body: %s", e.StatusCode, e.Body)
+}
+
+// Is matches the error against the errors of the API by code, or by status
+// if there is no known code. Only the error field of the payload is looked
+// at, never the rest of the body, which may echo titles or urls.
+func (e *APIError) Is(target error) bool {
+	if err, ok := errorCodes[e.Code]; ok {
+		return err == target
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusUnauthorized:
+		return target == ErrNotLoggedIn
+	}
+	// Some versions add details to the code, like "couldnt_create_post: ..."
+	for _, word := range strings.FieldsFunc(e.Code, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if err, ok := errorCodes[word]; ok {
+			return err == target
+		}
+	}
+	return false
+}
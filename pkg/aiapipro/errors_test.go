@@ -0,0 +1,34 @@
+package aiapipro
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		target error
+		want   bool
+	}{
+		{"code", 400, `{"error":"person_is_banned_from_site"}`, ErrBanned, true},
+		{"other code", 400, `{"error":"couldnt_create_post"}`, ErrBanned, false},
+		{"code with details", 400, `{"error":"couldnt_create_post: timeout"}`, ErrCouldntCreatePost, true},
+		{"status 429", 429, `{}`, ErrRateLimited, true},
+		{"status 401", 401, `not json`, ErrNotLoggedIn, true},
+		{"code beats status", 401, `{"error":"incorrect_login"}`, ErrNotLoggedIn, false},
+		{"echoed title", 400, `{"error":"unknown","post":{"name":"site_ban of the week"}}`, ErrBanned, false},
+		{"plain body", 400, `person_is_banned_from_site`, ErrBanned, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newAPIError(&http.Response{StatusCode: tt.status, Header: http.Header{}}, []byte(tt.body))
+			if got := errors.Is(err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%q, %v) = %v, want %v", tt.body, tt.target, got, tt.want)
+			}
+		})
+	}
+}
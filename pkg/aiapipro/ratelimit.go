@@ -0,0 +1,181 @@
+package aiapipro
+
+import (
+	"errors"
+	"log/slog"
+	"newsbots/pkg/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint classes with their own rate limit
+const (
+	classRead     = "read"
+	classPost     = "post"
+	classVote     = "vote"
+	classRegister = "register"
+	classLogin    = "login"
+)
+
+const (
+	// RateLimitRetries is how often a rate limited request is retried
+	RateLimitRetries = 3
+	// RateLimitBackoff is the first wait after a rate limited request, it
+	// doubles with every retry unless the instance sends Retry-After
+	RateLimitBackoff = 5 * time.Second
+)
+
+// RateLimit is Lemmy's local_site_rate_limit: Count requests per PerSecond
+// seconds, per class.
+type RateLimit struct {
+	Message           int `json:"message"`
+	MessagePerSecond  int `json:"message_per_second"`
+	Post              int `json:"post"`
+	PostPerSecond     int `json:"post_per_second"`
+	Register          int `json:"register"`
+	RegisterPerSecond int `json:"register_per_second"`
+	Image             int `json:"image"`
+	ImagePerSecond    int `json:"image_per_second"`
+	Comment           int `json:"comment"`
+	CommentPerSecond  int `json:"comment_per_second"`
+	Search            int `json:"search"`
+	SearchPerSecond   int `json:"search_per_second"`
+}
+
+// DefaultRateLimit are Lemmy's defaults, used if the site info can't be read.
+var DefaultRateLimit = RateLimit{
+	Message:           180,
+	MessagePerSecond:  60,
+	Post:              6,
+	PostPerSecond:     600,
+	Register:          3,
+	RegisterPerSecond: 3600,
+	Image:             6,
+	ImagePerSecond:    3600,
+	Comment:           6,
+	CommentPerSecond:  600,
+	Search:            60,
+	SearchPerSecond:   600,
+}
+
+// bucket is a token bucket. Tokens go negative for waiting requests.
+type bucket struct {
+	capacity float64
+	// rate is the refill in tokens per second
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(count, perSecond int) *bucket {
+	if count <= 0 || perSecond <= 0 {
+		// Unlimited
+		return nil
+	}
+	return &bucket{
+		capacity: float64(count),
+		rate:     float64(count) / float64(perSecond),
+		tokens:   float64(count),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// limiter holds a bucket per class, set up from the site info on first use.
+// Until the site info is known it uses the default limits.
+type limiter struct {
+	mutex    sync.Mutex
+	buckets  map[string]*bucket
+	fromSite bool
+}
+
+func (l *limiter) setLimits(limit RateLimit) {
+	// Lemmy counts everything besides posts and registrations in the one
+	// message bucket
+	message := newBucket(limit.Message, limit.MessagePerSecond)
+	l.buckets = map[string]*bucket{
+		classRead:     message,
+		classPost:     newBucket(limit.Post, limit.PostPerSecond),
+		classVote:     message,
+		classRegister: newBucket(limit.Register, limit.RegisterPerSecond),
+		classLogin:    message,
+	}
+}
+
+// wait blocks until the class may send the next request.
+func (c *Client) wait(class string) {
+	c.limiter.mutex.Lock()
+	if c.limiter.buckets == nil || !c.limiter.fromSite {
+		_, rateLimit, known := c.site()
+		if c.limiter.buckets == nil || known {
+			c.limiter.setLimits(rateLimit)
+			c.limiter.fromSite = known
+		}
+	}
+	wait := time.Duration(0)
+	if b := c.limiter.buckets[class]; b != nil {
+		wait = b.reserve(time.Now())
+	}
+	c.limiter.mutex.Unlock()
+
+	if wait > 0 {
+		slog.Debug("rate limited by client", "class", class, "wait", wait)
+		metrics.Observe("newsbots_rate_limit_wait_seconds", wait.Seconds(), "class", class)
+		time.Sleep(wait)
+	}
+}
+
+// drain empties the bucket of the class after the instance limited us, so
+// the following requests wait too.
+func (c *Client) drain(class string) {
+	c.limiter.mutex.Lock()
+	defer c.limiter.mutex.Unlock()
+	if b := c.limiter.buckets[class]; b != nil && b.tokens > 0 {
+		b.tokens = 0
+	}
+}
+
+// endpointClass returns the rate limit class of the request.
+func endpointClass(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	switch {
+	case path == "/api/v3/user/register":
+		return classRegister
+	case path == "/api/v3/user/login":
+		return classLogin
+	case path == "/api/v3/post" && method == "POST":
+		return classPost
+	case path == "/api/v3/post/like":
+		return classVote
+	}
+	return classRead
+}
+
+// retryAfter returns the wait before the next attempt, from the Retry-After
+// header or doubling backoff.
+func retryAfter(err error, attempt int) time.Duration {
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) && apiErr.RetryAfter != "" {
+		seconds, parseErr := strconv.Atoi(apiErr.RetryAfter)
+		if parseErr == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return RateLimitBackoff << attempt
+}
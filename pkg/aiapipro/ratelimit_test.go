@@ -0,0 +1,71 @@
+package aiapipro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMessageBucketShared(t *testing.T) {
+	l := limiter{}
+	l.setLimits(RateLimit{Message: 3, MessagePerSecond: 60, Post: 1, PostPerSecond: 600})
+	now := time.Now()
+
+	// Reads, votes and logins take from the same message bucket
+	for _, class := range []string{classRead, classVote, classLogin} {
+		if wait := l.buckets[class].reserve(now); wait != 0 {
+			t.Errorf("%s waits %s within the limit", class, wait)
+		}
+	}
+	for _, class := range []string{classRead, classVote, classLogin} {
+		if wait := l.buckets[class].reserve(now); wait == 0 {
+			t.Errorf("%s does not wait after the message limit", class)
+		}
+	}
+	if wait := l.buckets[classPost].reserve(now); wait != 0 {
+		t.Errorf("post waits %s for messages", wait)
+	}
+}
+
+func TestLimitsFromSiteAfterFailure(t *testing.T) {
+	siteFails := atomic.Bool{}
+	siteFails.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/site" {
+			w.Write([]byte("{}"))
+			return
+		}
+		if siteFails.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		resp := getSiteResponse{Version: "0.19.3"}
+		resp.SiteView.LocalSiteRateLimit = RateLimit{Message: 2, MessagePerSecond: 60}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+	c := NewClient(nil)
+	c.BaseURL = server.URL
+
+	c.wait(classRead)
+	if b := c.limiter.buckets[classRead]; b == nil || b.capacity != float64(DefaultRateLimit.Message) {
+		t.Fatalf("got bucket %+v after the failed site info, want the default limit", b)
+	}
+
+	// The site info is read again after SiteRetry, its limits replace the
+	// defaults
+	siteFails.Store(false)
+	c.siteInfo.mutex.Lock()
+	c.siteInfo.fetched = time.Now().Add(-SiteRetry - time.Second)
+	c.siteInfo.mutex.Unlock()
+	c.wait(classRead)
+	if b := c.limiter.buckets[classRead]; b == nil || b.capacity != 2 {
+		t.Fatalf("got bucket %+v, want the limit of the site", b)
+	}
+	if !c.limiter.fromSite {
+		t.Errorf("limits not marked as from the site")
+	}
+}
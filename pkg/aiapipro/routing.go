@@ -0,0 +1,177 @@
+package aiapipro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"newsbots/pkg/posts"
+	"os"
+	"strings"
+)
+
+// DefaultCommunityID is used if no routing rule matches
+const DefaultCommunityID = 4
+
+// RoutingRule maps a post to a community. All conditions given in a rule
+// must match, empty conditions are ignored. The first matching rule wins.
+type RoutingRule struct {
+	Host          string   `json:"host,omitempty"`
+	PathPrefix    string   `json:"path_prefix,omitempty"`
+	TitleKeywords []string `json:"title_keywords,omitempty"`
+	Feed          string   `json:"feed,omitempty"`
+	Topic         string   `json:"topic,omitempty"`
+	CommunityID   int      `json:"community_id,omitempty"`
+	// Community is resolved to an ID through the Lemmy API
+	Community string `json:"community,omitempty"`
+
+	titleKeywords *posts.KeywordMatcher
+}
+
+type RoutingConfig struct {
+	DefaultCommunityID int           `json:"default_community_id,omitempty"`
+	DefaultCommunity   string        `json:"default_community,omitempty"`
+	Rules              []RoutingRule `json:"rules"`
+}
+
+type Router struct {
+	client      *Client
+	llm         *posts.PromptBetter
+	config      RoutingConfig
+	communities map[string]int
+}
+
+func LoadRouter(client *Client, llm *posts.PromptBetter, configPath string) (*Router, error) {
+	configJSON, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read routing config: %w", err)
+	}
+	config := RoutingConfig{}
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal routing config: %w", err)
+	}
+	return NewRouter(client, llm, config)
+}
+
+func NewRouter(client *Client, llm *posts.PromptBetter, config RoutingConfig) (*Router, error) {
+	for k, rule := range config.Rules {
+		if rule.CommunityID == 0 && rule.Community == "" {
+			return nil, fmt.Errorf("routing rule %d has no community", k)
+		}
+		if len(rule.TitleKeywords) == 0 {
+			continue
+		}
+		keywordConfig := posts.KeywordConfig{Threshold: 1}
+		for _, term := range rule.TitleKeywords {
+			keywordConfig.Keywords = append(keywordConfig.Keywords, posts.Keyword{Term: term})
+		}
+		matcher, err := posts.NewKeywordMatcher(keywordConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile title keywords of routing rule %d: %w", k, err)
+		}
+		config.Rules[k].titleKeywords = matcher
+	}
+
+	return &Router{
+		client:      client,
+		llm:         llm,
+		config:      config,
+		communities: make(map[string]int),
+	}, nil
+}
+
+// Route returns the community ID for the post. The LLM is only asked for the
+// topic if a rule needs it, at most once, and the topic is stored in the post.
+func (r *Router) Route(post *posts.Post) (int, error) {
+	postURL, err := url.Parse(post.Url)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse url: %w", err)
+	}
+	// Reader links carry the real url in the query
+	if readerURL := postURL.Query().Get("url"); postURL.Host == "reader.aiapipro.com" && readerURL != "" {
+		if u, err := url.Parse(readerURL); err == nil {
+			postURL = u
+		}
+	}
+	host := strings.TrimPrefix(strings.ToLower(postURL.Host), "www.")
+	classified := post.Topic != ""
+
+	for _, rule := range r.config.Rules {
+		if rule.Host != "" && host != rule.Host && !strings.HasSuffix(host, "."+rule.Host) {
+			continue
+		}
+		if rule.PathPrefix != "" && !strings.HasPrefix(postURL.Path, rule.PathPrefix) {
+			continue
+		}
+		if rule.Feed != "" && rule.Feed != post.Feed {
+			continue
+		}
+		if rule.titleKeywords != nil && !rule.titleKeywords.Match(post.Title) {
+			continue
+		}
+		if rule.Topic != "" {
+			// Ask once per post, a failed or unknown answer is no topic
+			if !classified {
+				classified = true
+				post.Topic, err = r.llm.ClassifyTopic(post.Title, post.Excerpt)
+				if err != nil {
+					post.Logger("route").Warn("could not ClassifyTopic, route without topic", "error", err)
+					post.Topic = ""
+				}
+			}
+			if rule.Topic != post.Topic {
+				continue
+			}
+		}
+
+		return r.communityID(rule.CommunityID, rule.Community)
+	}
+
+	return r.Default()
+}
+
+// Default returns the configured default community, or DefaultCommunityID
+// if none is configured.
+func (r *Router) Default() (int, error) {
+	if r.config.DefaultCommunityID == 0 && r.config.DefaultCommunity == "" {
+		return DefaultCommunityID, nil
+	}
+	return r.communityID(r.config.DefaultCommunityID, r.config.DefaultCommunity)
+}
+
+func (r *Router) communityID(id int, name string) (int, error) {
+	if id != 0 {
+		return id, nil
+	}
+	if id, found := r.communities[name]; found {
+		return id, nil
+	}
+	id, err := r.client.GetCommunityID(name)
+	if err != nil {
+		return 0, fmt.Errorf("could not GetCommunityID for %q: %w", name, err)
+	}
+	r.communities[name] = id
+	return id, nil
+}
+
+type getCommunityResponse struct {
+	CommunityView struct {
+		Community struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"community"`
+	} `json:"community_view"`
+}
+
+// GetCommunityID resolves a community name like "papers" to its ID.
+func (c *Client) GetCommunityID(name string) (int, error) {
+	resp := getCommunityResponse{}
+	err := c.do("GET", "/api/v3/community?name="+url.QueryEscape(name), "", nil, &resp)
+	if err != nil {
+		return 0, fmt.Errorf("could not get community: %w", err)
+	}
+	if resp.CommunityView.Community.ID == 0 {
+		return 0, fmt.Errorf("community %q not found", name)
+	}
+	return resp.CommunityView.Community.ID, nil
+}
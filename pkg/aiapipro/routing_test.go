@@ -0,0 +1,125 @@
+package aiapipro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"newsbots/pkg/posts"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	rules := []RoutingRule{
+		{Topic: "papers", CommunityID: 10},
+		{Topic: "tools", CommunityID: 11},
+		{Host: "arxiv.org", CommunityID: 12},
+	}
+	tests := []struct {
+		name      string
+		answer    string
+		status    int
+		config    RoutingConfig
+		url       string
+		wantID    int
+		wantTopic string
+		wantCalls int
+	}{
+		{
+			name:      "topic",
+			answer:    "Tools",
+			url:       "https://example.com/a",
+			wantID:    11,
+			wantTopic: "tools",
+			wantCalls: 1,
+		},
+		{
+			name:      "unknown topic asked once",
+			answer:    "cooking",
+			url:       "https://arxiv.org/abs/1",
+			wantID:    12,
+			wantCalls: 1,
+		},
+		{
+			name:      "classify error",
+			status:    http.StatusInternalServerError,
+			url:       "https://arxiv.org/abs/1",
+			wantID:    12,
+			wantCalls: 1,
+		},
+		{
+			name:      "configured default",
+			answer:    "cooking",
+			config:    RoutingConfig{DefaultCommunityID: 7},
+			url:       "https://example.com/a",
+			wantID:    7,
+			wantCalls: 1,
+		},
+		{
+			name:      "default",
+			answer:    "cooking",
+			url:       "https://example.com/a",
+			wantID:    DefaultCommunityID,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if tt.status != 0 {
+					http.Error(w, "failed", tt.status)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]string{"data": tt.answer})
+			}))
+			defer server.Close()
+			llm := posts.NewPromptBetter("test")
+			llm.BaseURL = server.URL
+
+			config := tt.config
+			config.Rules = append([]RoutingRule(nil), rules...)
+			router, err := NewRouter(NewClient(nil), llm, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			post := posts.Post{Url: tt.url, Title: "Title"}
+			id, err := router.Route(&post)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID {
+				t.Errorf("routed to %d, want %d", id, tt.wantID)
+			}
+			if post.Topic != tt.wantTopic {
+				t.Errorf("topic %q, want %q", post.Topic, tt.wantTopic)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d classify calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRouterDefault(t *testing.T) {
+	tests := []struct {
+		config RoutingConfig
+		want   int
+	}{
+		{RoutingConfig{}, DefaultCommunityID},
+		{RoutingConfig{DefaultCommunityID: 7}, 7},
+	}
+	for _, tt := range tests {
+		router, err := NewRouter(NewClient(nil), nil, tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := router.Default()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != tt.want {
+			t.Errorf("Default() = %d with %+v, want %d", id, tt.config, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package aiapipro
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/metrics"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lemmy JWTs carry no expiry, they are valid until the password changes.
+// Sessions are still renewed after SessionMaxAge since they were issued, and
+// SessionRefreshBefore before a given expiry.
+var (
+	SessionMaxAge        = 24 * time.Hour
+	SessionRefreshBefore = 10 * time.Minute
+)
+
+type session struct {
+	jwt     string
+	expires time.Time
+}
+
+type jwtClaims struct {
+	Exp int64 `json:"exp"`
+	Iat int64 `json:"iat"`
+}
+
+// jwtExpiry decodes the expiry of the JWT, without checking its signature.
+func jwtExpiry(jwt string, now time.Time) time.Time {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return now.Add(SessionMaxAge)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return now.Add(SessionMaxAge)
+	}
+	claims := jwtClaims{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return now.Add(SessionMaxAge)
+	}
+	switch {
+	case claims.Exp > 0:
+		return time.Unix(claims.Exp, 0)
+	case claims.Iat > 0:
+		return time.Unix(claims.Iat, 0).Add(SessionMaxAge)
+	}
+	return now.Add(SessionMaxAge)
+}
+
+// Session returns a JWT of the account. It is cached until shortly before
+// it expires, so each account logs in once per run. Concurrent calls for an
+// account share one login, other accounts do not wait for it.
+func (c *Client) Session(username string) (string, error) {
+	lock := c.accountLock(username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := clock.Now()
+	c.sessionsMutex.Lock()
+	s, found := c.sessions[username]
+	c.sessionsMutex.Unlock()
+	if found && now.Before(s.expires.Add(-SessionRefreshBefore)) {
+		return s.jwt, nil
+	}
+
+	jwt, err := c.LoginUser(username)
+	if err != nil {
+		return "", err
+	}
+	if jwt == "" {
+		return "", fmt.Errorf("login of %q returned an empty jwt", username)
+	}
+	c.sessionsMutex.Lock()
+	c.storeSession(username, jwt, now)
+	c.sessionsMutex.Unlock()
+	return jwt, nil
+}
+
+// accountLock returns the lock which serializes the logins and password
+// changes of the account.
+func (c *Client) accountLock(username string) *sync.Mutex {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+	lock, found := c.accountLocks[username]
+	if !found {
+		lock = &sync.Mutex{}
+		c.accountLocks[username] = lock
+	}
+	return lock
+}
+
+func (c *Client) storeSession(username, jwt string, now time.Time) {
+	if jwt == "" {
+		return
+	}
+	c.sessions[username] = session{
+		jwt:     jwt,
+		expires: jwtExpiry(jwt, now),
+	}
+}
+
+// Invalidate drops the cached session of the account.
+func (c *Client) Invalidate(username string) {
+	c.sessionsMutex.Lock()
+	defer c.sessionsMutex.Unlock()
+	delete(c.sessions, username)
+}
+
+// withSession runs the request with the session of the account. If the API
+// rejects the session, the account logs in again and the request is retried
+// once.
+func (c *Client) withSession(username string, request func(jwt string) error) error {
+	jwt, err := c.Session(username)
+	if err != nil {
+		return fmt.Errorf("could not get session of %q: %w", username, err)
+	}
+	err = request(jwt)
+	if !errors.Is(err, ErrNotLoggedIn) {
+		return err
+	}
+
+	slog.Info("session rejected, login again", "user", username)
+	metrics.Add("newsbots_session_relogins_total", 1)
+	c.Invalidate(username)
+	jwt, err = c.Session(username)
+	if err != nil {
+		return fmt.Errorf("could not get session of %q: %w", username, err)
+	}
+	return request(jwt)
+}
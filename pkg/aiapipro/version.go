@@ -0,0 +1,115 @@
+package aiapipro
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SiteRetry is how long the client speaks the old dialect with default
+// rate limits after the site info could not be read, before it tries again.
+const SiteRetry = 5 * time.Minute
+
+// legacyTimeLayout is the timestamp format before Lemmy 0.19, in UTC
+// without a zone.
+const legacyTimeLayout = "2006-01-02T15:04:05.999999"
+
+type getSiteResponse struct {
+	Version  string `json:"version"`
+	SiteView struct {
+		LocalSiteRateLimit RateLimit `json:"local_site_rate_limit"`
+	} `json:"site_view"`
+}
+
+// siteInfo is what the client needs to know about the instance.
+type siteInfo struct {
+	mutex     sync.Mutex
+	fetched   time.Time
+	failed    bool
+	version   string
+	rateLimit RateLimit
+}
+
+// site reads the site info of the instance once. Without it the client
+// assumes an instance before 0.19 with default rate limits, known is false
+// then.
+func (c *Client) site() (version string, rateLimit RateLimit, known bool) {
+	c.siteInfo.mutex.Lock()
+	defer c.siteInfo.mutex.Unlock()
+
+	if c.siteInfo.fetched.IsZero() || (c.siteInfo.failed && time.Since(c.siteInfo.fetched) > SiteRetry) {
+		c.siteInfo.fetched = time.Now()
+		resp := getSiteResponse{}
+		err := c.send("GET", "/api/v3/site", "", nil, &resp)
+		c.siteInfo.failed = err != nil
+		if err != nil {
+			slog.Warn("could not get site info, using defaults", "error", err)
+			c.siteInfo.version = ""
+			c.siteInfo.rateLimit = DefaultRateLimit
+		} else {
+			slog.Debug("site info", "version", resp.Version)
+			c.siteInfo.version = resp.Version
+			c.siteInfo.rateLimit = resp.SiteView.LocalSiteRateLimit
+			if c.siteInfo.rateLimit == (RateLimit{}) {
+				c.siteInfo.rateLimit = DefaultRateLimit
+			}
+		}
+	}
+	return c.siteInfo.version, c.siteInfo.rateLimit, !c.siteInfo.failed
+}
+
+// Version returns the Lemmy version of the instance, like "0.19.3". Empty
+// if unknown.
+func (c *Client) Version() string {
+	version, _, _ := c.site()
+	return version
+}
+
+// v019 reports if the instance speaks the API of Lemmy 0.19 or later: auth
+// in the header instead of the body and cursor pagination.
+func (c *Client) v019() bool {
+	major, minor, err := parseVersion(c.Version())
+	if err != nil {
+		return false
+	}
+	return major > 0 || minor >= 19
+}
+
+// bodyAuth returns the JWT for the auth field of the request body, which
+// only Lemmy before 0.19 reads.
+func (c *Client) bodyAuth(jwt string) string {
+	if c.v019() {
+		return ""
+	}
+	return jwt
+}
+
+// parseVersion parses versions like "0.19.3" or "0.19.0-rc.1".
+func parseVersion(version string) (major, minor int, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid version %q", version)
+	}
+	major, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version %q: %w", version, err)
+	}
+	minor, err = strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version %q: %w", version, err)
+	}
+	return major, minor, nil
+}
+
+// ParseTime parses a timestamp of the API. Lemmy 0.19 sends RFC3339 with a
+// zone, older versions UTC without one.
+func ParseTime(timestamp string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(legacyTimeLayout, timestamp)
+}
@@ -0,0 +1,249 @@
+// Package cassette records the outbound HTTP exchanges of a run to a
+// directory and replays them offline, to reproduce a run exactly.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Redacted replaces secrets in the cassette.
+const Redacted = "REDACTED"
+
+// redactedHeaders never end up in a cassette.
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// redactedFields are JSON fields of bodies which never end up in a cassette.
+var redactedFields = map[string]bool{
+	"password":            true,
+	"password_verify":     true,
+	"new_password":        true,
+	"new_password_verify": true,
+	"old_password":        true,
+	"auth":                true,
+	"jwt":                 true,
+}
+
+// Exchange is one recorded request and its response.
+type Exchange struct {
+	Request  Message `json:"request"`
+	Response Message `json:"response"`
+}
+
+// Message is a request or a response. Bodies which are not UTF-8 are kept
+// in BodyBase64.
+type Message struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+}
+
+// Transport records to or replays from the cassette in Dir. Requests are
+// matched by method, url and redacted body, repeated requests in order.
+type Transport struct {
+	Mode string
+	Dir  string
+	// Next sends the requests while recording
+	Next http.RoundTripper
+
+	mutex sync.Mutex
+	seen  map[string]int
+}
+
+// FromSpec creates the transport from a spec like "record:dir" or
+// "replay:dir", as in HTTP_CASSETTE.
+func FromSpec(spec string, next http.RoundTripper) (*Transport, error) {
+	mode, dir, ok := strings.Cut(spec, ":")
+	if !ok || dir == "" || (mode != ModeRecord && mode != ModeReplay) {
+		return nil, fmt.Errorf("invalid cassette %q, expect 'record:<dir>' or 'replay:<dir>'", spec)
+	}
+	if mode == ModeRecord {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("could not create cassette dir: %w", err)
+		}
+	}
+	return &Transport{Mode: mode, Dir: dir, Next: next, seen: make(map[string]int)}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %w", err)
+	}
+	reqBody = redactBody(reqBody)
+
+	key := fingerprint(req.Method, req.URL.String(), reqBody)
+	t.mutex.Lock()
+	n := t.seen[key]
+	t.seen[key]++
+	t.mutex.Unlock()
+
+	if t.Mode == ModeReplay {
+		return t.replay(req, key, n)
+	}
+	return t.record(req, key, n, reqBody)
+}
+
+func (t *Transport) file(key string, n int) string {
+	return filepath.Join(t.Dir, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+func (t *Transport) record(req *http.Request, key string, n int, reqBody []byte) (*http.Response, error) {
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response body: %w", err)
+	}
+
+	exchange := Exchange{
+		Request:  newMessage(req.Header, reqBody),
+		Response: newMessage(resp.Header, redactBody(respBody)),
+	}
+	exchange.Request.Method = req.Method
+	exchange.Request.URL = req.URL.String()
+	exchange.Response.StatusCode = resp.StatusCode
+
+	exchangeJSON, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal exchange: %w", err)
+	}
+	err = os.WriteFile(t.file(key, n), exchangeJSON, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not write exchange: %w", err)
+	}
+	return resp, nil
+}
+
+// replay answers with the recorded response. A request repeated more often
+// than recorded gets the last recorded response.
+func (t *Transport) replay(req *http.Request, key string, n int) (*http.Response, error) {
+	var exchangeJSON []byte
+	var err error
+	for ; n >= 0; n-- {
+		exchangeJSON, err = os.ReadFile(t.file(key, n))
+		if !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no recorded exchange for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read exchange: %w", err)
+	}
+	exchange := Exchange{}
+	err = json.Unmarshal(exchangeJSON, &exchange)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal exchange: %w", err)
+	}
+
+	body := []byte(exchange.Response.Body)
+	if exchange.Response.BodyBase64 != "" {
+		body, err = base64.StdEncoding.DecodeString(exchange.Response.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode response body: %w", err)
+		}
+	}
+	// Redaction may have changed the length
+	header := exchange.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Response.StatusCode, http.StatusText(exchange.Response.StatusCode)),
+		StatusCode:    exchange.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// readBody reads the body and puts a fresh reader of it back.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+func newMessage(header http.Header, body []byte) Message {
+	m := Message{Header: header.Clone()}
+	for _, name := range redactedHeaders {
+		if m.Header.Get(name) != "" {
+			m.Header.Set(name, Redacted)
+		}
+	}
+	if utf8.Valid(body) {
+		m.Body = string(body)
+	} else {
+		m.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return m
+}
+
+// redactBody replaces the secret fields of a JSON object body. Other bodies
+// are returned as they are.
+func redactBody(body []byte) []byte {
+	fields := make(map[string]json.RawMessage)
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	redacted := false
+	for name := range fields {
+		if redactedFields[name] {
+			fields[name] = json.RawMessage(`"` + Redacted + `"`)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return body
+	}
+	redactedBody, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return redactedBody
+}
+
+func fingerprint(method, url string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, url)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
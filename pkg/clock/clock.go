@@ -0,0 +1,15 @@
+// Package clock is the time the bots decide on. A cassette replay freezes
+// it at the start of the recording.
+package clock
+
+import "time"
+
+// Now returns the current time, replace it to run at another time.
+var Now = time.Now
+
+// Freeze makes Now always return t.
+func Freeze(t time.Time) {
+	Now = func() time.Time {
+		return t
+	}
+}
@@ -0,0 +1,620 @@
+// Package lemmytest is an in-process stand-in for the Lemmy API, to run the
+// bots against httptest instead of the production site. It keeps users,
+// communities, posts and votes in memory and records every request.
+package lemmytest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const legacyTimeLayout = "2006-01-02T15:04:05.999999"
+
+// Request is a recorded request to the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+	// User is the authenticated user, if any
+	User string
+	Time time.Time
+}
+
+type User struct {
+	ID       int
+	Name     string
+	Password string
+}
+
+type Community struct {
+	ID   int
+	Name string
+}
+
+type Post struct {
+	ID          int
+	Name        string
+	URL         string
+	Body        string
+	CreatorID   int
+	CommunityID int
+	Removed     bool
+	Published   time.Time
+	Score       int
+	Upvotes     int
+	Downvotes   int
+}
+
+// failure is an error the server answers instead of handling the request.
+type failure struct {
+	status int
+	code   string
+	times  int
+}
+
+// Server is the fake Lemmy instance. Set the exported fields before the
+// first request.
+type Server struct {
+	*httptest.Server
+
+	// Version is reported by /api/v3/site. Since "0.19" auth is read from
+	// the header or cookie and posts are paged with a cursor, before from
+	// the auth field of the body or query.
+	Version string
+	// RejectDuplicates answers post_already_exists for a known url
+	RejectDuplicates bool
+	// Moderators may remove posts. Empty means everyone may.
+	Moderators map[string]bool
+	// RateLimit is the local_site_rate_limit the server reports. The
+	// server itself never limits, use Fail for that.
+	RateLimit map[string]int
+
+	mutex       sync.Mutex
+	users       map[string]*User
+	sessions    map[string]string
+	communities map[int]*Community
+	posts       []*Post
+	votes       map[string]int
+	requests    []Request
+	failures    map[string]*failure
+}
+
+// New starts a server which speaks Lemmy 0.19. Close it after use.
+func New() *Server {
+	s := &Server{
+		Version:     "0.19.3",
+		RateLimit:   defaultRateLimit(),
+		users:       make(map[string]*User),
+		sessions:    make(map[string]string),
+		communities: make(map[int]*Community),
+		votes:       make(map[string]int),
+		failures:    make(map[string]*failure),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddUser creates a user as if it registered.
+func (s *Server) AddUser(username, password string) *User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addUser(username, password)
+}
+
+func (s *Server) addUser(username, password string) *User {
+	user := &User{ID: len(s.users) + 1, Name: username, Password: password}
+	s.users[strings.ToLower(username)] = user
+	return user
+}
+
+// AddCommunity creates a community with the ID.
+func (s *Server) AddCommunity(id int, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.communities[id] = &Community{ID: id, Name: name}
+}
+
+// AddPost creates a post as the creator, which has to exist.
+func (s *Server) AddPost(creator, name, url string, communityID int, published time.Time) *Post {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	post := &Post{
+		ID:          len(s.posts) + 1,
+		Name:        name,
+		URL:         url,
+		CommunityID: communityID,
+		Published:   published.UTC(),
+	}
+	if user := s.users[strings.ToLower(creator)]; user != nil {
+		post.CreatorID = user.ID
+	}
+	s.posts = append(s.posts, post)
+	return post
+}
+
+// Posts returns a copy of all posts, also the removed ones.
+func (s *Server) Posts() []Post {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	posts := make([]Post, 0, len(s.posts))
+	for _, p := range s.posts {
+		posts = append(posts, *p)
+	}
+	return posts
+}
+
+// User returns the user, or nil.
+func (s *Server) User(username string) *User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user := s.users[strings.ToLower(username)]
+	if user == nil {
+		return nil
+	}
+	copied := *user
+	return &copied
+}
+
+// Requests returns the recorded requests, oldest first.
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Fail answers the next times requests to the path with the status and the
+// Lemmy error code, like 429 and "rate_limit_error".
+func (s *Server) Fail(path string, status int, code string, times int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[path] = &failure{status: status, code: code, times: times}
+}
+
+// defaultRateLimit is high enough to never slow down the clients.
+func defaultRateLimit() map[string]int {
+	rateLimit := make(map[string]int)
+	for _, class := range []string{"message", "post", "register", "image", "comment", "search"} {
+		rateLimit[class] = 10000
+		rateLimit[class+"_per_second"] = 1
+	}
+	return rateLimit
+}
+
+func (s *Server) v019() bool {
+	parts := strings.SplitN(s.Version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, _ := strconv.Atoi(parts[0])
+	minor, _ := strconv.Atoi(parts[1])
+	return major > 0 || minor >= 19
+}
+
+func (s *Server) timestamp(t time.Time) string {
+	if s.v019() {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return t.UTC().Format(legacyTimeLayout)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user := s.authenticate(r, body)
+	request := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Body:   string(body),
+		Time:   time.Now(),
+	}
+	if user != nil {
+		request.User = user.Name
+	}
+	s.requests = append(s.requests, request)
+
+	if f := s.failures[r.URL.Path]; f != nil && f.times > 0 {
+		f.times--
+		writeError(w, f.status, f.code)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v3/site":
+		writeJSON(w, map[string]interface{}{
+			"version":   s.Version,
+			"site_view": map[string]interface{}{"local_site_rate_limit": s.RateLimit},
+		})
+	case r.Method == "POST" && r.URL.Path == "/api/v3/user/register":
+		s.register(w, body)
+	case r.Method == "POST" && r.URL.Path == "/api/v3/user/login":
+		s.login(w, body)
+	case r.Method == "PUT" && r.URL.Path == "/api/v3/user/change_password":
+		s.changePassword(w, user, body)
+	case r.Method == "GET" && r.URL.Path == "/api/v3/post/list":
+		s.listPosts(w, r)
+	case r.Method == "POST" && r.URL.Path == "/api/v3/post":
+		s.createPost(w, user, body)
+	case r.Method == "POST" && r.URL.Path == "/api/v3/post/remove":
+		s.removePost(w, user, body)
+	case r.Method == "POST" && r.URL.Path == "/api/v3/post/like":
+		s.likePost(w, user, body)
+	case r.Method == "GET" && r.URL.Path == "/api/v3/community":
+		s.getCommunity(w, r)
+	case r.Method == "GET" && r.URL.Path == "/api/v3/community/list":
+		s.listCommunities(w)
+	default:
+		writeError(w, http.StatusNotFound, "unknown_endpoint")
+	}
+}
+
+// authenticate returns the user of the JWT, from where the version of the
+// server reads it.
+func (s *Server) authenticate(r *http.Request, body []byte) *User {
+	jwt := ""
+	if s.v019() {
+		jwt = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if cookie, err := r.Cookie("jwt"); jwt == "" && err == nil {
+			jwt = cookie.Value
+		}
+	} else {
+		auth := struct {
+			Auth string `json:"auth"`
+		}{}
+		_ = json.Unmarshal(body, &auth)
+		jwt = auth.Auth
+		if jwt == "" {
+			jwt = r.URL.Query().Get("auth")
+		}
+	}
+	username, ok := s.sessions[jwt]
+	if !ok {
+		return nil
+	}
+	return s.users[username]
+}
+
+func (s *Server) newSession(user *User) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"sub": user.ID,
+		"iss": "lemmytest",
+		"iat": time.Now().Unix(),
+	})
+	signature := make([]byte, 16)
+	_, _ = rand.Read(signature)
+	jwt := header + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + hex.EncodeToString(signature)
+	s.sessions[jwt] = strings.ToLower(user.Name)
+	return jwt
+}
+
+func (s *Server) register(w http.ResponseWriter, body []byte) {
+	req := struct {
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		PasswordVerify string `json:"password_verify"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	if req.Password != req.PasswordVerify {
+		writeError(w, http.StatusBadRequest, "passwords_dont_match")
+		return
+	}
+	if s.users[strings.ToLower(req.Username)] != nil {
+		writeError(w, http.StatusBadRequest, "user_already_exists")
+		return
+	}
+	user := s.addUser(req.Username, req.Password)
+	writeJSON(w, map[string]interface{}{"jwt": s.newSession(user)})
+}
+
+func (s *Server) login(w http.ResponseWriter, body []byte) {
+	req := struct {
+		UsernameOrEmail string `json:"username_or_email"`
+		Password        string `json:"password"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	user := s.users[strings.ToLower(req.UsernameOrEmail)]
+	if user == nil {
+		writeError(w, http.StatusBadRequest, "couldnt_find_that_username_or_email")
+		return
+	}
+	if user.Password != req.Password {
+		writeError(w, http.StatusBadRequest, "incorrect_login")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"jwt": s.newSession(user)})
+}
+
+func (s *Server) changePassword(w http.ResponseWriter, user *User, body []byte) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "not_logged_in")
+		return
+	}
+	req := struct {
+		NewPassword       string `json:"new_password"`
+		NewPasswordVerify string `json:"new_password_verify"`
+		OldPassword       string `json:"old_password"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	if req.NewPassword != req.NewPasswordVerify {
+		writeError(w, http.StatusBadRequest, "passwords_dont_match")
+		return
+	}
+	if req.OldPassword != user.Password {
+		writeError(w, http.StatusBadRequest, "incorrect_login")
+		return
+	}
+	user.Password = req.NewPassword
+	// A new password ends all sessions
+	for jwt, username := range s.sessions {
+		if username == strings.ToLower(user.Name) {
+			delete(s.sessions, jwt)
+		}
+	}
+	writeJSON(w, map[string]interface{}{"jwt": s.newSession(user)})
+}
+
+func (s *Server) postView(p *Post) map[string]interface{} {
+	published := s.timestamp(p.Published)
+	return map[string]interface{}{
+		"post": map[string]interface{}{
+			"id":           p.ID,
+			"name":         p.Name,
+			"url":          p.URL,
+			"body":         p.Body,
+			"creator_id":   p.CreatorID,
+			"community_id": p.CommunityID,
+			"removed":      p.Removed,
+			"published":    published,
+			"ap_id":        fmt.Sprintf("%s/post/%d", s.URL, p.ID),
+			"local":        true,
+		},
+		"counts": map[string]interface{}{
+			"post_id":             p.ID,
+			"score":               p.Score,
+			"upvotes":             p.Upvotes,
+			"downvotes":           p.Downvotes,
+			"published":           published,
+			"newest_comment_time": published,
+			"community_id":        p.CommunityID,
+			"creator_id":          p.CreatorID,
+		},
+	}
+}
+
+// listPosts lists the posts which are not removed, newest first. Pages are
+// numbered from 1, since 0.19 also a page_cursor from next_page works.
+func (s *Server) listPosts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+
+	visible := make([]*Post, 0, len(s.posts))
+	for _, p := range s.posts {
+		if !p.Removed {
+			visible = append(visible, p)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		return visible[i].ID > visible[j].ID
+	})
+
+	start := 0
+	if cursor := query.Get("page_cursor"); cursor != "" && s.v019() {
+		beforeID, err := strconv.Atoi(strings.TrimPrefix(cursor, "P"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "couldnt_parse_pagination_token")
+			return
+		}
+		start = sort.Search(len(visible), func(i int) bool {
+			return visible[i].ID < beforeID
+		})
+	} else if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 1 {
+		start = (page - 1) * limit
+	}
+	if start > len(visible) {
+		start = len(visible)
+	}
+	end := start + limit
+	if end > len(visible) {
+		end = len(visible)
+	}
+
+	views := make([]map[string]interface{}, 0, end-start)
+	for _, p := range visible[start:end] {
+		views = append(views, s.postView(p))
+	}
+	resp := map[string]interface{}{"posts": views}
+	if s.v019() && end < len(visible) {
+		resp["next_page"] = fmt.Sprintf("P%d", visible[end-1].ID)
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) findPost(id int) *Post {
+	for _, p := range s.posts {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) createPost(w http.ResponseWriter, user *User, body []byte) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "not_logged_in")
+		return
+	}
+	req := struct {
+		Name        string `json:"name"`
+		URL         string `json:"url"`
+		Body        string `json:"body"`
+		CommunityID int    `json:"community_id"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	if s.communities[req.CommunityID] == nil {
+		writeError(w, http.StatusBadRequest, "couldnt_find_community")
+		return
+	}
+	if s.RejectDuplicates {
+		for _, p := range s.posts {
+			if p.URL == req.URL && !p.Removed {
+				writeError(w, http.StatusBadRequest, "post_already_exists")
+				return
+			}
+		}
+	}
+	post := &Post{
+		ID:          len(s.posts) + 1,
+		Name:        req.Name,
+		URL:         req.URL,
+		Body:        req.Body,
+		CreatorID:   user.ID,
+		CommunityID: req.CommunityID,
+		Published:   time.Now().UTC(),
+	}
+	s.posts = append(s.posts, post)
+	writeJSON(w, map[string]interface{}{"post_view": s.postView(post)})
+}
+
+func (s *Server) removePost(w http.ResponseWriter, user *User, body []byte) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "not_logged_in")
+		return
+	}
+	if len(s.Moderators) > 0 && !s.Moderators[strings.ToLower(user.Name)] {
+		writeError(w, http.StatusBadRequest, "not_a_moderator")
+		return
+	}
+	req := struct {
+		PostID  int  `json:"post_id"`
+		Removed bool `json:"removed"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	post := s.findPost(req.PostID)
+	if post == nil {
+		writeError(w, http.StatusBadRequest, "couldnt_find_post")
+		return
+	}
+	post.Removed = req.Removed
+	writeJSON(w, map[string]interface{}{"post_view": s.postView(post)})
+}
+
+func (s *Server) likePost(w http.ResponseWriter, user *User, body []byte) {
+	if user == nil {
+		writeError(w, http.StatusUnauthorized, "not_logged_in")
+		return
+	}
+	req := struct {
+		PostID int `json:"post_id"`
+		Score  int `json:"score"`
+	}{}
+	if !readJSON(w, body, &req) {
+		return
+	}
+	post := s.findPost(req.PostID)
+	if post == nil {
+		writeError(w, http.StatusBadRequest, "couldnt_find_post")
+		return
+	}
+
+	// Replace an earlier vote of the user
+	key := fmt.Sprintf("%d+%d", post.ID, user.ID)
+	switch s.votes[key] {
+	case 1:
+		post.Upvotes--
+	case -1:
+		post.Downvotes--
+	}
+	switch req.Score {
+	case 1:
+		post.Upvotes++
+	case -1:
+		post.Downvotes++
+	}
+	s.votes[key] = req.Score
+	post.Score = post.Upvotes - post.Downvotes
+	writeJSON(w, map[string]interface{}{"post_view": s.postView(post)})
+}
+
+func communityView(c *Community) map[string]interface{} {
+	return map[string]interface{}{
+		"community": map[string]interface{}{"id": c.ID, "name": c.Name},
+	}
+}
+
+func (s *Server) getCommunity(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	for _, c := range s.communities {
+		if c.ID == id || (name != "" && c.Name == name) {
+			writeJSON(w, map[string]interface{}{"community_view": communityView(c)})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "couldnt_find_community")
+}
+
+func (s *Server) listCommunities(w http.ResponseWriter) {
+	ids := make([]int, 0, len(s.communities))
+	for id := range s.communities {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	views := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		views = append(views, communityView(s.communities[id]))
+	}
+	writeJSON(w, map[string]interface{}{"communities": views})
+}
+
+func readJSON(w http.ResponseWriter, body []byte, v interface{}) bool {
+	err := json.Unmarshal(body, v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
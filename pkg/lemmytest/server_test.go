@@ -0,0 +1,177 @@
+package lemmytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// call sends the request and decodes the answer, the status is returned.
+func call(t *testing.T, s *Server, method, path, jwt string, in interface{}, out interface{}) int {
+	t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.URL+path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+jwt)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type listResponse struct {
+	Posts []struct {
+		Post struct {
+			ID int `json:"id"`
+		} `json:"post"`
+	} `json:"posts"`
+	NextPage string `json:"next_page"`
+}
+
+func (r listResponse) ids() []int {
+	ids := make([]int, 0, len(r.Posts))
+	for _, p := range r.Posts {
+		ids = append(ids, p.Post.ID)
+	}
+	return ids
+}
+
+func TestListPostsPaging(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		query    string
+		wantIDs  []int
+		wantNext string
+	}{
+		{"first page", "0.19.3", "limit=2", []int{5, 4}, "P4"},
+		{"cursor", "0.19.3", "limit=2&page_cursor=P4", []int{3, 2}, "P2"},
+		{"last cursor", "0.19.3", "limit=2&page_cursor=P2", []int{1}, ""},
+		{"page number", "0.19.3", "limit=2&page=3", []int{1}, ""},
+		{"legacy first page", "0.18.5", "limit=2", []int{5, 4}, ""},
+		{"legacy page", "0.18.5", "limit=2&page=2", []int{3, 2}, ""},
+		{"legacy ignores cursor", "0.18.5", "limit=2&page_cursor=P4", []int{5, 4}, ""},
+		{"beyond the end", "0.18.5", "limit=2&page=9", []int{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			defer s.Close()
+			s.Version = tt.version
+			s.AddUser("alice", "secret")
+			for i := 1; i <= 6; i++ {
+				p := s.AddPost("alice", fmt.Sprintf("post %d", i), fmt.Sprintf("https://example.com/%d", i), 4, time.Now())
+				// Removed posts are not listed
+				if i == 6 {
+					p.Removed = true
+				}
+			}
+
+			resp := listResponse{}
+			status := call(t, s, "GET", "/api/v3/post/list?"+tt.query, "", nil, &resp)
+			if status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			if fmt.Sprint(resp.ids()) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("ids %v, want %v", resp.ids(), tt.wantIDs)
+			}
+			if resp.NextPage != tt.wantNext {
+				t.Errorf("next_page %q, want %q", resp.NextPage, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		header   bool
+		bodyAuth bool
+		wantUser string
+	}{
+		{"header", "0.19.3", true, false, "alice"},
+		{"body auth ignored", "0.19.3", false, true, ""},
+		{"legacy body auth", "0.18.5", false, true, "alice"},
+		{"legacy header ignored", "0.18.5", true, false, ""},
+		{"none", "0.19.3", false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			defer s.Close()
+			s.Version = tt.version
+			s.AddUser("alice", "secret")
+			s.AddCommunity(4, "ai")
+
+			login := struct {
+				JWT string `json:"jwt"`
+			}{}
+			status := call(t, s, "POST", "/api/v3/user/login", "", map[string]string{
+				"username_or_email": "alice",
+				"password":          "secret",
+			}, &login)
+			if status != http.StatusOK || login.JWT == "" {
+				t.Fatalf("login status %d", status)
+			}
+
+			newPost := map[string]interface{}{
+				"name":         "A post",
+				"url":          "https://example.com/a",
+				"community_id": 4,
+			}
+			if tt.bodyAuth {
+				newPost["auth"] = login.JWT
+			}
+			jwt := ""
+			if tt.header {
+				jwt = login.JWT
+			}
+			apiErr := struct {
+				Error string `json:"error"`
+			}{}
+			status = call(t, s, "POST", "/api/v3/post", jwt, newPost, &apiErr)
+
+			requests := s.Requests()
+			last := requests[len(requests)-1]
+			if last.User != tt.wantUser {
+				t.Errorf("request user %q, want %q", last.User, tt.wantUser)
+			}
+			if tt.wantUser == "" {
+				if status != http.StatusUnauthorized || apiErr.Error != "not_logged_in" {
+					t.Errorf("got %d %q, want not_logged_in", status, apiErr.Error)
+				}
+				if len(s.Posts()) != 0 {
+					t.Errorf("post created without login")
+				}
+				return
+			}
+			if status != http.StatusOK {
+				t.Fatalf("status %d %q", status, apiErr.Error)
+			}
+			posts := s.Posts()
+			if len(posts) != 1 || posts[0].CreatorID != s.User("alice").ID {
+				t.Errorf("posts %+v, want one by alice", posts)
+			}
+		})
+	}
+}
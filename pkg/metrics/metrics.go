@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets of all histograms, in seconds
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type kind int
+
+const (
+	counter kind = iota
+	gauge
+	histogram
+)
+
+func (k kind) String() string {
+	switch k {
+	case gauge:
+		return "gauge"
+	case histogram:
+		return "histogram"
+	}
+	return "counter"
+}
+
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type family struct {
+	name   string
+	kind   kind
+	series map[string]*series
+}
+
+// Registry keeps counters and histograms by name and label values, and
+// writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// Default is the registry used by the package level functions
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+func Add(name string, value float64, labels ...string) {
+	Default.Add(name, value, labels...)
+}
+
+func Set(name string, value float64, labels ...string) {
+	Default.Set(name, value, labels...)
+}
+
+func Observe(name string, value float64, labels ...string) {
+	Default.Observe(name, value, labels...)
+}
+
+// Add increases the counter with the given label pairs, like
+// Add("newsbots_llm_calls_total", 1, "prompt", "rephrase-title").
+func (r *Registry) Add(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(name, counter, labels)
+	s.value += value
+}
+
+// Set sets the gauge with the given label pairs.
+func (r *Registry) Set(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(name, gauge, labels)
+	s.value = value
+}
+
+// Observe adds a value to the histogram with the given label pairs.
+func (r *Registry) Observe(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(name, histogram, labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(DefaultBuckets))
+	}
+	for k, upperBound := range DefaultBuckets {
+		if value <= upperBound {
+			s.buckets[k]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+// Sum returns the sum of all series of a counter, or of the observed values
+// of a histogram.
+func (r *Registry) Sum(name string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, found := r.families[name]
+	if !found {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range f.series {
+		sum += s.value
+	}
+	return sum
+}
+
+func (r *Registry) series(name string, k kind, labels []string) *series {
+	if len(labels)%2 != 0 {
+		labels = append(labels, "")
+	}
+
+	f, found := r.families[name]
+	if !found {
+		f = &family{
+			name:   name,
+			kind:   k,
+			series: make(map[string]*series),
+		}
+		r.families[name] = f
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, found := f.series[key]
+	if !found {
+		s = &series{labels: append([]string(nil), labels...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind); err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			var err error
+			switch f.kind {
+			case counter, gauge:
+				_, err = fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(s.labels), formatValue(s.value))
+			case histogram:
+				for k, upperBound := range DefaultBuckets {
+					labels := append(append([]string(nil), s.labels...), "le", formatValue(upperBound))
+					if _, err = fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels), s.buckets[k]); err != nil {
+						return err
+					}
+				}
+				labels := append(append([]string(nil), s.labels...), "le", "+Inf")
+				if _, err = fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels), s.count); err != nil {
+					return err
+				}
+				if _, err = fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(s.labels), formatValue(s.value)); err != nil {
+					return err
+				}
+				_, err = fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(s.labels), s.count)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WriteTextfile writes the metrics for the node exporter textfile collector.
+// The file is replaced atomically, so the collector never reads half of it.
+func (r *Registry) WriteTextfile(filePath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := r.WriteText(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temp file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("could not chmod temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("could not rename temp file: %w", err)
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Transport counts all responses by host and status class and observes
+// their latency.
+type Transport struct {
+	Next http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Next.RoundTrip(req)
+	Observe("newsbots_http_request_duration_seconds", time.Since(start).Seconds(), "host", req.URL.Host)
+
+	class := "error"
+	if err == nil {
+		class = fmt.Sprintf("%dxx", resp.StatusCode/100)
+	}
+	Add("newsbots_http_responses_total", 1, "host", req.URL.Host, "class", class)
+
+	return resp, err
+}
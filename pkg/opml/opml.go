@@ -0,0 +1,130 @@
+package opml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Namespace of the custom outline attributes with the bot options, like
+// newsbots:check_title="true".
+const (
+	Namespace = "https://news.aiapipro.com/ns/newsbots"
+	prefix    = "newsbots"
+)
+
+type Document struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Head    Head      `xml:"head"`
+	Body    []Outline `xml:"body>outline"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Outline struct {
+	Text     string     `xml:"text,attr"`
+	Title    string     `xml:"title,attr,omitempty"`
+	Type     string     `xml:"type,attr,omitempty"`
+	XMLURL   string     `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string     `xml:"htmlUrl,attr,omitempty"`
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Outlines []Outline  `xml:"outline"`
+}
+
+func New(title string) *Document {
+	return &Document{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+}
+
+func Read(r io.Reader) (*Document, error) {
+	doc := &Document{}
+	err := xml.NewDecoder(r).Decode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode opml: %w", err)
+	}
+	return doc, nil
+}
+
+func (d *Document) Write(w io.Writer) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	// The namespace is declared once on the root element, the outlines use
+	// the prefix.
+	start := xml.StartElement{
+		Name: xml.Name{Local: "opml"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns:" + prefix}, Value: Namespace}},
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.EncodeElement(d, start)
+	if err != nil {
+		return fmt.Errorf("could not encode opml: %w", err)
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// Feeds returns all outlines with a feed url, flattening nested categories.
+func (d *Document) Feeds() []Outline {
+	return feeds(d.Body)
+}
+
+func feeds(outlines []Outline) []Outline {
+	result := make([]Outline, 0)
+	for _, o := range outlines {
+		if o.XMLURL != "" {
+			result = append(result, o)
+		}
+		result = append(result, feeds(o.Outlines)...)
+	}
+	return result
+}
+
+// Attr returns the custom attribute with the given name in the newsbots
+// namespace.
+func (o Outline) Attr(name string) (string, bool) {
+	for _, a := range o.Attrs {
+		if isAttr(a, name) {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// isAttr also accepts the prefix without declared namespace, as some feed
+// readers drop the declaration on the root element.
+func isAttr(a xml.Attr, name string) bool {
+	switch a.Name.Space {
+	case Namespace, prefix:
+		return a.Name.Local == name
+	case "":
+		return a.Name.Local == prefix+":"+name
+	}
+	return false
+}
+
+// SetAttr sets the custom attribute with the given name in the newsbots
+// namespace.
+func (o *Outline) SetAttr(name, value string) {
+	for k, a := range o.Attrs {
+		if isAttr(a, name) {
+			o.Attrs[k].Value = value
+			return
+		}
+	}
+	// The prefix is written as part of the name, encoding/xml would
+	// declare the namespace again on every outline.
+	o.Attrs = append(o.Attrs, xml.Attr{Name: xml.Name{Local: prefix + ":" + name}, Value: value})
+}
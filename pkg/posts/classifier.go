@@ -0,0 +1,165 @@
+package posts
+
+import (
+	"fmt"
+	"math"
+	"newsbots/pkg/store"
+	"strings"
+)
+
+// Items the classifier is at least this sure about skip the LLM check.
+var (
+	ClassifierRelevantAbove   = 0.97
+	ClassifierIrrelevantBelow = 0.03
+	// Minimum number of training examples per class before the classifier
+	// is trusted at all.
+	ClassifierMinExamples = 50
+	// Only the first words of the excerpt are used, so long articles do not
+	// drown out the title.
+	classifierMaxTokens = 120
+)
+
+// Classifier is a multinomial naive Bayes model over title and excerpt
+// tokens. It is trained from our own history: published posts, posts
+// removed by moderation and cached LLM verdicts. All examples are the text
+// of the feed item, see ClassifierText, never the text the LLM wrote.
+type Classifier struct {
+	docs   [2]int
+	tokens [2]int
+	counts [2]map[string]int
+	vocab  map[string]bool
+}
+
+type classifierExample struct {
+	Text     string `json:"text"`
+	Relevant bool   `json:"relevant"`
+}
+
+func NewClassifier() *Classifier {
+	return &Classifier{
+		counts: [2]map[string]int{make(map[string]int), make(map[string]int)},
+		vocab:  make(map[string]bool),
+	}
+}
+
+func classIndex(relevant bool) int {
+	if relevant {
+		return 1
+	}
+	return 0
+}
+
+func (c *Classifier) Train(text string, relevant bool) {
+	class := classIndex(relevant)
+	c.docs[class]++
+	for _, t := range classifierTokens(text) {
+		c.counts[class][t]++
+		c.tokens[class]++
+		c.vocab[t] = true
+	}
+}
+
+// Ready reports if enough examples of both classes were seen to trust
+// the predictions.
+func (c *Classifier) Ready() bool {
+	return c.docs[0] >= ClassifierMinExamples && c.docs[1] >= ClassifierMinExamples
+}
+
+// Predict returns the probability that the text is relevant for the site.
+func (c *Classifier) Predict(text string) float64 {
+	totalDocs := c.docs[0] + c.docs[1]
+	if totalDocs == 0 {
+		return 0.5
+	}
+
+	var logProb [2]float64
+	for class := 0; class < 2; class++ {
+		// Laplace smoothing for both prior and likelihood
+		logProb[class] = math.Log(float64(c.docs[class]+1) / float64(totalDocs+2))
+		denominator := float64(c.tokens[class] + len(c.vocab) + 1)
+		for _, t := range classifierTokens(text) {
+			logProb[class] += math.Log(float64(c.counts[class][t]+1) / denominator)
+		}
+	}
+
+	return 1 / (1 + math.Exp(logProb[0]-logProb[1]))
+}
+
+// Confident returns the verdict if the classifier is sure enough about the
+// text, otherwise ok is false and the item should go to the LLM.
+func (c *Classifier) Confident(text string) (relevant bool, probability float64, ok bool) {
+	if c == nil || !c.Ready() {
+		return false, 0, false
+	}
+	probability = c.Predict(text)
+	switch {
+	case probability >= ClassifierRelevantAbove:
+		return true, probability, true
+	case probability <= ClassifierIrrelevantBelow:
+		return false, probability, true
+	}
+	return false, probability, false
+}
+
+func classifierTokens(text string) []string {
+	tokens := splitWords(strings.ToLower(text))
+	filtered := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if len(t) < 2 {
+			continue
+		}
+		filtered = append(filtered, t)
+		if len(filtered) >= classifierMaxTokens {
+			break
+		}
+	}
+	return filtered
+}
+
+var examples = store.Bucket[classifierExample]{Prefix: store.ExamplePrefix}
+
+// ClassifierText is the text of a feed item the classifier works on.
+func ClassifierText(title, excerpt string) string {
+	return title + " " + excerpt
+}
+
+// RecordExample stores a labelled example for future classifier training.
+// Examples are keyed by the canonical url, so the reader url of a post
+// finds the example of the article.
+func RecordExample(db *store.Store, url, text string, relevant bool) error {
+	return examples.Save(db, CanonicalURL(url), &classifierExample{
+		Text:     text,
+		Relevant: relevant,
+	})
+}
+
+// RelabelExample changes the label of the example of the url, like for a
+// post removed by moderation. Urls without example are skipped, as only
+// the feed text makes a good example.
+func RelabelExample(db *store.Store, url string, relevant bool) error {
+	return db.Update(func(tx *store.Tx) error {
+		example, err := examples.Get(tx, CanonicalURL(url))
+		if err != nil || example == nil {
+			return err
+		}
+		example.Relevant = relevant
+		return examples.Put(tx, CanonicalURL(url), example)
+	})
+}
+
+// LoadClassifier trains a new classifier from all examples stored in the db.
+func LoadClassifier(db *store.Store) (*Classifier, error) {
+	c := NewClassifier()
+
+	err := db.View(func(tx *store.Tx) error {
+		return examples.Each(tx, func(url string, example *classifierExample) error {
+			c.Train(example.Text, example.Relevant)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load examples: %w", err)
+	}
+
+	return c, nil
+}
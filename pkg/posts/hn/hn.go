@@ -3,24 +3,15 @@ package hn
 import (
 	"fmt"
 	"newsbots/pkg/posts"
-	"strconv"
-
-	"github.com/dgraph-io/badger/v4"
+	"newsbots/pkg/store"
 )
 
 var hnAPIURL string = "https://hacker-news.firebaseio.com/v0"
 
-func GetNew(db *badger.DB, maxElements int) (posts.Posts, error) {
-
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
-
-	key := "lastHNID"
-
-	minId := -1
-	v, err := txn.Get([]byte(key))
-	if err == nil {
-		minId, _ = strconv.Atoi(v.String())
+func GetNew(db *store.Store, maxElements int) (posts.Posts, error) {
+	minId, err := db.HNCursor()
+	if err != nil {
+		return nil, err
 	}
 
 	storyIDs := make([]int, 0)
@@ -32,14 +23,9 @@ func GetNew(db *badger.DB, maxElements int) (posts.Posts, error) {
 		return nil, fmt.Errorf("no single story found")
 	}
 
-	err = txn.Set([]byte(key), []byte(fmt.Sprintf("%d", storyIDs[0])))
+	err = db.SetHNCursor(storyIDs[0])
 	if err != nil {
-		return nil, fmt.Errorf("could not set to db: %w", err)
-	}
-
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		return nil, fmt.Errorf("could not commit to db: %w", err)
+		return nil, err
 	}
 
 	returnPosts := make(posts.Posts, 0, len(storyIDs))
@@ -1,55 +1,32 @@
 package posts
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/metrics"
+	"newsbots/pkg/store"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/dgraph-io/badger/v4"
 	"jaytaylor.com/html2text"
 )
 
-var aiKeywords = []string{
-	" ML ", " AI ", " AI-", " GAN ", " KNN ", " NLP ", " CNN ", "LLM",
-	" Machine Learning ", " Artificial Intelligence ", " Neural Networks ", " Deep Learning ",
-	" Data Science ", " Algorithm ", " Automation ", " Predictive Modeling ",
-	" Natural Language Processing ", " Reinforcement Learning ",
-	" Supervised Learning ", " Unsupervised Learning ", " Semi-Supervised Learning ",
-	" Ensemble Learning ", " Transfer Learning ", " Convolutional Neural Networks ",
-	" Recurrent Neural Networks ", " Generative Adversarial Networks ",
-	" Feature Engineering ", " Gradient Descent ", " Overfitting ", " Bias-Variance Tradeoff ",
-	" Hyperparameters ", " Backpropagation ",
-	" ChatGPT ", "GPT", "llama2", "llama", "PaLM", " BART ",
-}
-
-func init() {
-	for _, keyword := range aiKeywords {
-		clean := strings.TrimSpace(keyword)
-		aiKeywords = append(aiKeywords, " "+clean)
-		aiKeywords = append(aiKeywords, clean+" ")
-	}
-
-}
-
-func FilterPostsByAIKeywordsInTitle(rssPosts Posts) Posts {
+func FilterPostsByAIKeywordsInTitle(rssPosts Posts, keywords *KeywordMatcher) Posts {
 	filteredPosts := make(Posts, 0, len(rssPosts))
 
 	for _, p := range rssPosts {
-		for _, keyword := range aiKeywords {
-			if strings.Contains(strings.ToLower(p.Title), strings.ToLower(keyword)) {
-				filteredPosts = append(filteredPosts, p)
-				break
-			}
+		if !keywords.Match(p.Title) {
+			p.Logger("title_keywords").Info("dropped, no keyword in title")
+			continue
 		}
+		filteredPosts = append(filteredPosts, p)
 	}
 
-	log.Printf("Filtered out %d in 'FilterPostsByAIKeywordsInTitle'", len(rssPosts)-len(filteredPosts))
+	slog.Info("filtered", "stage", "title_keywords", "dropped", len(rssPosts)-len(filteredPosts))
 
 	return filteredPosts
 }
@@ -61,12 +38,13 @@ func FilterPostsByTitleRegex(rssPosts Posts, reg *regexp.Regexp, match bool) Pos
 		regMatched := reg.MatchString(p.Title)
 		if (match && !regMatched) || (!match && regMatched) {
 			// Regex not matched
+			p.Logger("title_regex").Info("dropped by title regex", "regex", reg.String())
 			continue
 		}
 		filteredPosts = append(filteredPosts, p)
 	}
 
-	log.Printf("Filtered out %d in 'FilterPostsByTitleRegex'", len(rssPosts)-len(filteredPosts))
+	slog.Info("filtered", "stage", "title_regex", "dropped", len(rssPosts)-len(filteredPosts))
 
 	return filteredPosts
 }
@@ -77,9 +55,10 @@ func EnrichPostsWithExcerpt(posts Posts) (Posts, error) {
 	enrichedPosts := make(Posts, 0, len(posts))
 
 	for _, p := range posts {
+		logger := p.Logger("excerpt")
 		resp, err := http.Get(p.Url)
 		if err != nil {
-			log.Println(fmt.Errorf("could not http get url %q: %w", p.Url, err))
+			logger.Warn("could not http get url", "error", err)
 			continue
 		}
 		plain, err := html2text.FromReader(resp.Body, html2text.Options{
@@ -87,11 +66,12 @@ func EnrichPostsWithExcerpt(posts Posts) (Posts, error) {
 			TextOnly:  true,
 		})
 		if err != nil {
-			log.Println(fmt.Errorf("could not read html from resp.Body %q: %w", p.Url, err))
+			logger.Warn("could not read html from resp.Body", "error", err)
 			continue
 		}
 		//plain = urlRegex.ReplaceAllString(plain, "")
 		if plain == "" {
+			logger.Info("dropped, empty page")
 			continue
 		}
 
@@ -107,24 +87,19 @@ func EnrichPostsWithExcerpt(posts Posts) (Posts, error) {
 	return enrichedPosts, nil
 
 }
-func FilterPostsByAIContent(db *badger.DB, posts Posts) (Posts, error) {
-	filteredPosts := make(Posts, 0, len(posts))
 
-	txn := db.NewTransaction(true)
-	defer txn.Discard()
+// FilterPostsByAIContent keeps posts whose content is about AI. The local
+// classifier decides the confident items, only the uncertain ones are sent
+// to the LLM. Pass a nil classifier to check every post with the LLM.
+func FilterPostsByAIContent(db *store.Store, llm *PromptBetter, keywords *KeywordMatcher, classifier *Classifier, posts Posts) (Posts, error) {
+	filteredPosts := make(Posts, 0, len(posts))
 
 	for _, p := range posts {
-		key := "post+" + p.Url
+		logger := p.Logger("ai_content")
 
 		// Check again if we find keyword in body. Try to reduce GPT cost
-		keywordFound := false
-		for _, keyword := range aiKeywords {
-			if strings.Contains(strings.ToLower(p.Excerpt), strings.ToLower(keyword)) {
-				keywordFound = true
-				break
-			}
-		}
-		if !keywordFound {
+		if !keywords.Match(p.Excerpt) {
+			logger.Info("dropped, no keyword in excerpt")
 			continue
 		}
 
@@ -132,15 +107,30 @@ func FilterPostsByAIContent(db *badger.DB, posts Posts) (Posts, error) {
 			continue
 		}
 
-		articleIsAboutAI, err := promptBetterCheckArticle(p.Excerpt)
+		classifierText := ClassifierText(p.Title, p.Excerpt)
+		if relevant, probability, ok := classifier.Confident(classifierText); ok {
+			logger.Info("classifier decided", "relevant", relevant, "probability", probability)
+			metrics.Add("newsbots_classifier_decisions_total", 1, "relevant", strconv.FormatBool(relevant))
+			if relevant {
+				filteredPosts = append(filteredPosts, p)
+			}
+			continue
+		}
+
+		check, err := llm.CheckArticle(p.Excerpt)
+		if err != nil {
+			return nil, fmt.Errorf("could not CheckArticle: %w", err)
+		}
+		err = RecordExample(db, p.Url, classifierText, check.AboutAI())
 		if err != nil {
-			return nil, fmt.Errorf("could not promptBetterCheckArticle: %w", err)
+			logger.Warn("could not RecordExample", "error", err)
 		}
-		if !articleIsAboutAI {
-			// Not about AI
-			err = txn.Set([]byte(key), []byte(p.Url))
+		if !check.AboutAI() {
+			// Not about AI, check again when the verdict expires
+			logger.Info("dropped, LLM says not about AI", "rating", check.Rating)
+			err = db.SaveVerdict(newVerdict(p.Url, check, clock.Now()))
 			if err != nil {
-				log.Println(fmt.Errorf("could not set to db: %w", err))
+				logger.Warn("could not SaveVerdict", "error", err)
 			}
 			continue
 		}
@@ -149,157 +139,52 @@ func FilterPostsByAIContent(db *badger.DB, posts Posts) (Posts, error) {
 
 	}
 
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		log.Println(fmt.Errorf("could not commit to db: %w", err))
-	}
-	log.Printf("Filtered out %d in 'FilterPostsByAIContent'", len(posts)-len(filteredPosts))
+	slog.Info("filtered", "stage", "ai_content", "dropped", len(posts)-len(filteredPosts))
 
 	return filteredPosts, nil
 }
 
-type pbCheckArticlePayload struct {
-	ArticleText string `json:"article_text"`
-}
-type pbResponse struct {
-	Data string `json:"data"`
-}
-
-var promptBetterToken = "6kS38IwhXp3LZPWiXkb42MW84eJlNnMg4OYXXcfb"
-
-var nonNumericRegex = regexp.MustCompile(`[^0-9.]`)
-
-func promptBetterCheckArticle(articleExcerpt string) (bool, error) {
-	payloadBytes, err := json.Marshal(pbCheckArticlePayload{
-		ArticleText: articleExcerpt,
-	})
-	if err != nil {
-		return false, fmt.Errorf("could not marshal input body: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.promptbetter.ai/v1/2qcutndk/run/check-if-post-is-about-ai", bytes.NewReader(payloadBytes))
-	if err != nil {
-		return false, fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+promptBetterToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false, fmt.Errorf("could not create request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false, fmt.Errorf("could not read resp body: %w", err)
-	}
-
-	rData := pbResponse{}
-	err = json.Unmarshal(respBody, &rData)
-	if err != nil {
-		return false, fmt.Errorf("could not unmarshal resp body: %w", err)
-	}
-
-	intResp := nonNumericRegex.ReplaceAllString(rData.Data, "")
-	if intResp == "" {
-		return false, nil
-	}
-	if strings.Contains(intResp, ".") {
-		return false, nil
-	}
-
-	intRating, err := strconv.Atoi(intResp)
-	if err != nil {
-		return false, fmt.Errorf("could not convert response to int: %w", err)
-	}
-
-	return intRating > 5 && intRating <= 10, nil
-
-}
-
-type pbSummarizeArticlePayload struct {
-	Title string `json:"title"`
-	Post  string `json:"post"`
-}
+// NegativeVerdictTTL is how long a url the LLM rejected is not checked
+// again.
+var NegativeVerdictTTL = 30 * 24 * time.Hour
 
-func PromptBetterSummarizeArticle(title, excerpt string) (string, error) {
-	payloadBytes, err := json.Marshal(pbSummarizeArticlePayload{
-		Title: title,
-		Post:  excerpt,
-	})
-	if err != nil {
-		return "", fmt.Errorf("could not marshal input body: %w", err)
-	}
+// maxVerdictReason cuts the answer of the LLM stored with a verdict.
+const maxVerdictReason = 200
 
-	req, err := http.NewRequest("POST", "https://api.promptbetter.ai/v1/2qcutndk/run/write-summary-of-website", bytes.NewReader(payloadBytes))
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
+func newVerdict(url string, check ArticleCheck, now time.Time) *store.Verdict {
+	reason := []rune(strings.Join(strings.Fields(check.Answer), " "))
+	if len(reason) > maxVerdictReason {
+		reason = reason[:maxVerdictReason]
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+promptBetterToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
+	return &store.Verdict{
+		URL:           url,
+		Score:         check.Rating,
+		Reason:        string(reason),
+		PromptVersion: CheckArticleVersion,
+		Created:       now,
+		Expires:       now.Add(NegativeVerdictTTL),
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("could not read resp body: %w", err)
-	}
-
-	rData := pbResponse{}
-	err = json.Unmarshal(respBody, &rData)
-	if err != nil {
-		return "", fmt.Errorf("could not unmarshal resp body: %w", err)
-	}
-
-	return rData.Data, nil
-
-}
-
-type pbRephraseTitlePayload struct {
-	Title string `json:"title"`
-	Excerpt string `json:"excerpt"`
 }
 
-func PromptBetterRephraseTitle(title, excerpt string) (string, error) {
-	payloadBytes, err := json.Marshal(pbRephraseTitlePayload{
-		Title: title,
-		Excerpt: excerpt,
-	})
-	if err != nil {
-		return "", fmt.Errorf("could not marshal input body: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.promptbetter.ai/v1/2qcutndk/run/rephrase-title", bytes.NewReader(payloadBytes))
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
-	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+promptBetterToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("could not create request: %w", err)
-	}
-	defer resp.Body.Close()
+// FilterRejected drops the posts the LLM rejected before, as long as the
+// verdict holds.
+func FilterRejected(db *store.Store, posts Posts) (Posts, error) {
+	filteredPosts := make(Posts, 0, len(posts))
+	now := clock.Now()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("could not read resp body: %w", err)
+	for _, p := range posts {
+		verdict, err := db.Verdict(p.Url)
+		if err != nil {
+			return nil, err
+		}
+		if verdict != nil && verdict.Valid(now, CheckArticleVersion) {
+			p.Logger("rejected").Info("dropped, rejected by LLM before", "rating", verdict.Score, "expires", verdict.Expires)
+			continue
+		}
+		filteredPosts = append(filteredPosts, p)
 	}
 
-	rData := pbResponse{}
-	err = json.Unmarshal(respBody, &rData)
-	if err != nil {
-		return "", fmt.Errorf("could not unmarshal resp body: %w", err)
-	}
+	slog.Info("filtered", "stage", "rejected", "dropped", len(posts)-len(filteredPosts))
 
-	return rData.Data, nil
+	return filteredPosts, nil
 }
@@ -0,0 +1,213 @@
+package posts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Keyword is a single word or phrase of the keyword matcher. Acronyms like
+// "AI" or "NLP" are matched case sensitive, also in their plural and with a
+// version like "GPTs" and "GPT4". Everything else is matched on lowercased
+// word stems.
+type Keyword struct {
+	Term          string  `json:"term"`
+	Weight        float64 `json:"weight,omitempty"`
+	CaseSensitive *bool   `json:"case_sensitive,omitempty"`
+}
+
+type KeywordConfig struct {
+	// A text matches if the summed weights of the found keywords minus the
+	// weights of the found negative keywords reach the threshold.
+	Threshold        float64   `json:"threshold"`
+	Keywords         []Keyword `json:"keywords"`
+	NegativeKeywords []Keyword `json:"negative_keywords"`
+}
+
+type KeywordMatcher struct {
+	threshold float64
+	keywords  []compiledKeyword
+}
+
+type compiledKeyword struct {
+	term          string
+	words         []string
+	weight        float64
+	caseSensitive bool
+}
+
+func LoadKeywordMatcher(configPath string) (*KeywordMatcher, error) {
+	configJSON, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyword config: %w", err)
+	}
+	config := KeywordConfig{}
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal keyword config: %w", err)
+	}
+	return NewKeywordMatcher(config)
+}
+
+func NewKeywordMatcher(config KeywordConfig) (*KeywordMatcher, error) {
+	m := &KeywordMatcher{
+		threshold: config.Threshold,
+	}
+	if m.threshold <= 0 {
+		m.threshold = 1
+	}
+
+	compile := func(k Keyword, sign float64) error {
+		weight := k.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		caseSensitive := isAcronym(k.Term)
+		if k.CaseSensitive != nil {
+			caseSensitive = *k.CaseSensitive
+		}
+
+		words := splitWords(k.Term)
+		if len(words) == 0 {
+			return fmt.Errorf("keyword %q has no words", k.Term)
+		}
+		for i, w := range words {
+			words[i] = normalizeWord(w, caseSensitive)
+		}
+
+		m.keywords = append(m.keywords, compiledKeyword{
+			term:          k.Term,
+			words:         words,
+			weight:        sign * weight,
+			caseSensitive: caseSensitive,
+		})
+		return nil
+	}
+
+	for _, k := range config.Keywords {
+		if err := compile(k, 1); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range config.NegativeKeywords {
+		if err := compile(k, -1); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Score sums the weights of all keywords found in the text. Every keyword
+// counts once, no matter how often it is found.
+func (m *KeywordMatcher) Score(text string) (score float64, matched []string) {
+	words := splitWords(text)
+	exact := make([]string, len(words))
+	folded := make([]string, len(words))
+	for i, w := range words {
+		exact[i] = normalizeWord(w, true)
+		folded[i] = normalizeWord(w, false)
+	}
+
+	for _, k := range m.keywords {
+		textWords, equal := folded, equalWord
+		if k.caseSensitive {
+			textWords, equal = exact, equalAcronym
+		}
+		if containsPhrase(textWords, k.words, equal) {
+			score += k.weight
+			matched = append(matched, k.term)
+		}
+	}
+	return score, matched
+}
+
+func (m *KeywordMatcher) Match(text string) bool {
+	score, _ := m.Score(text)
+	return score >= m.threshold
+}
+
+func containsPhrase(words, phrase []string, equal func(word, keyword string) bool) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		found := true
+		for j := range phrase {
+			if !equal(words[i+j], phrase[j]) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords splits a text at everything that is neither letter nor digit,
+// so "GPT-4" becomes "GPT" and "4", but "GPTQ" stays one word.
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func isAcronym(term string) bool {
+	if strings.ContainsAny(term, " -") || len(term) < 2 {
+		return false
+	}
+	hasLetter := false
+	for _, r := range term {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	return hasLetter
+}
+
+func equalWord(word, keyword string) bool {
+	return word == keyword
+}
+
+// equalAcronym also matches the word without a version or plural suffix, so
+// "GPT4", "GPT4o" and "GPTs" match "GPT", but "GPTQ" does not.
+func equalAcronym(word, keyword string) bool {
+	if word == keyword {
+		return true
+	}
+	withoutLower := strings.TrimRightFunc(word, unicode.IsLower)
+	withoutVersion := strings.TrimRightFunc(withoutLower, unicode.IsDigit)
+	if withoutVersion != withoutLower {
+		return withoutVersion == keyword
+	}
+	return strings.TrimSuffix(word, "s") == keyword
+}
+
+func normalizeWord(word string, caseSensitive bool) string {
+	if caseSensitive {
+		return word
+	}
+	return stemWord(strings.ToLower(word))
+}
+
+// stemWord strips the common English inflections, so "networks",
+// "learning" and "learned" match "network" and "learn".
+func stemWord(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return word[:len(word)-2]
+	case len(word) > 4 && strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,52 @@
+package posts
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeywordMatcherScore(t *testing.T) {
+	m, err := NewKeywordMatcher(KeywordConfig{
+		Threshold: 2,
+		Keywords: []Keyword{
+			{Term: "AI"},
+			{Term: "GPT"},
+			{Term: "CNN"},
+			{Term: "neural network", Weight: 2},
+		},
+		NegativeKeywords: []Keyword{
+			{Term: "crypto", Weight: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text        string
+		wantScore   float64
+		wantMatched string
+		wantMatch   bool
+	}{
+		{"Kaiser opens a new clinic", 0, "[]", false},
+		{"GPTQ quantization explained", 0, "[]", false},
+		{"AI startup raises funding", 1, "[AI]", false},
+		{"The ai in said is no acronym", 0, "[]", false},
+		{"GPT4 beats GPT-3", 1, "[GPT]", false},
+		{"GPT4o is out", 1, "[GPT]", false},
+		{"Custom GPTs and AIs", 2, "[AI GPT]", true},
+		{"CNNs for images", 1, "[CNN]", false},
+		{"Neural networks explained", 2, "[neural network]", true},
+		{"AI coins are the new crypto", -1, "[AI crypto]", false},
+		{"Neural networks, AI and crypto", 1, "[AI neural network crypto]", false},
+	}
+	for _, tt := range tests {
+		score, matched := m.Score(tt.text)
+		if score != tt.wantScore || fmt.Sprint(matched) != tt.wantMatched {
+			t.Errorf("Score(%q) = %v %v, want %v %s", tt.text, score, matched, tt.wantScore, tt.wantMatched)
+		}
+		if got := m.Match(tt.text); got != tt.wantMatch {
+			t.Errorf("Match(%q) = %v, want %v", tt.text, got, tt.wantMatch)
+		}
+	}
+}
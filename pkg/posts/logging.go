@@ -0,0 +1,38 @@
+package posts
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+const readerPrefix = "https://reader.aiapipro.com/?url="
+
+// CanonicalURL returns the url without reader prefix, tracking parameters
+// and fragment, so the same article is logged the same way in every stage.
+func CanonicalURL(rawURL string) string {
+	rawURL = strings.TrimPrefix(strings.TrimSpace(rawURL), readerPrefix)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	query := u.Query()
+	for k := range query {
+		if strings.HasPrefix(k, "utm_") || k == "source" || k == "ref" {
+			query.Del(k)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// Logger returns a logger with the feed, the canonical url of the post and
+// the pipeline stage as attributes.
+func (p Post) Logger(stage string) *slog.Logger {
+	return slog.With("feed", p.Feed, "item", CanonicalURL(p.Url), "stage", stage)
+}
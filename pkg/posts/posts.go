@@ -15,7 +15,27 @@ type Post struct {
 	Url         string `json:"url"`
 	Description string `json:"body"`
 	Excerpt     string `json:"-"`
-	JWT         string `json:"-"`
+	// Account is the bot account which publishes the post
+	Account string `json:"-"`
+	// Feed is the url of the feed the post was found in
+	Feed        string `json:"-"`
+	Topic       string `json:"-"`
+	CommunityID int    `json:"-"`
+	// Priority of the feed, higher is published first
+	Priority int `json:"-"`
+	// SourceText is the title and excerpt of the feed item, before the LLM
+	// rewrites them. The classifier learns from it.
+	SourceText string `json:"-"`
+}
+
+// HTTPError is a response with a status other than 200.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("status not 200, but %d. Body: %s", e.StatusCode, e.Body)
 }
 
 func GetJSON(url string, out interface{}) error {
@@ -29,6 +49,9 @@ func GetJSON(url string, out interface{}) error {
 	if err != nil {
 		return fmt.Errorf("could not read body: %w", err)
 	}
+	if resp.StatusCode != 200 {
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
 
 	err = json.Unmarshal(respBody, out)
 	if err != nil {
@@ -59,7 +82,7 @@ func PostJSON(url string, in, out interface{}) error {
 		return fmt.Errorf("could not read body: %w", err)
 	}
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("status not 200, but %d. Body: %s", resp.StatusCode, string(respBody))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 	if out != nil {
 
@@ -0,0 +1,175 @@
+package posts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"newsbots/pkg/metrics"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const PromptBetterBaseURL = "https://api.promptbetter.ai/v1/2qcutndk/run"
+
+// PromptBetter runs the prompts of the bots on promptbetter.ai.
+type PromptBetter struct {
+	BaseURL string
+	token   string
+}
+
+func NewPromptBetter(token string) *PromptBetter {
+	return &PromptBetter{
+		BaseURL: PromptBetterBaseURL,
+		token:   token,
+	}
+}
+
+type pbResponse struct {
+	Data string `json:"data"`
+}
+
+// run runs the prompt with the payload as input and returns the answer.
+func (pb *PromptBetter) run(prompt string, payload interface{}) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal input body: %w", err)
+	}
+
+	metrics.Add("newsbots_llm_calls_total", 1, "prompt", prompt)
+
+	req, err := http.NewRequest("POST", pb.BaseURL+"/"+prompt, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+pb.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read resp body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	rData := pbResponse{}
+	err = json.Unmarshal(respBody, &rData)
+	if err != nil {
+		return "", fmt.Errorf("could not unmarshal resp body: %w", err)
+	}
+	return rData.Data, nil
+}
+
+type pbCheckArticlePayload struct {
+	ArticleText string `json:"article_text"`
+}
+
+var nonNumericRegex = regexp.MustCompile(`[^0-9.]`)
+
+// CheckArticleVersion is the version of the check-if-post-is-about-ai
+// prompt. Bump it when the prompt changes, this invalidates the negative
+// verdicts of the old prompt, see 'newsbots verdicts invalidate --help'.
+var CheckArticleVersion = "1"
+
+// ArticleCheck is the answer of the LLM to CheckArticle. Rating is 0 if the
+// answer was no whole number.
+type ArticleCheck struct {
+	Rating int
+	Answer string
+}
+
+// AboutAI reports if the rating counts as about AI.
+func (c ArticleCheck) AboutAI() bool {
+	return c.Rating > 5 && c.Rating <= 10
+}
+
+// CheckArticle asks the LLM to rate from 1 to 10 how much the article is
+// about AI. Ratings above 5 count as about AI.
+func (pb *PromptBetter) CheckArticle(articleExcerpt string) (ArticleCheck, error) {
+	answer, err := pb.run("check-if-post-is-about-ai", pbCheckArticlePayload{
+		ArticleText: articleExcerpt,
+	})
+	if err != nil {
+		return ArticleCheck{}, err
+	}
+	check := ArticleCheck{Answer: answer}
+
+	intResp := nonNumericRegex.ReplaceAllString(answer, "")
+	if intResp == "" {
+		return check, nil
+	}
+	if strings.Contains(intResp, ".") {
+		return check, nil
+	}
+
+	check.Rating, err = strconv.Atoi(intResp)
+	if err != nil {
+		return ArticleCheck{}, fmt.Errorf("could not convert response to int: %w", err)
+	}
+
+	return check, nil
+}
+
+type pbSummarizeArticlePayload struct {
+	Title string `json:"title"`
+	Post  string `json:"post"`
+}
+
+func (pb *PromptBetter) SummarizeArticle(title, excerpt string) (string, error) {
+	return pb.run("write-summary-of-website", pbSummarizeArticlePayload{
+		Title: title,
+		Post:  excerpt,
+	})
+}
+
+type pbRephraseTitlePayload struct {
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+}
+
+func (pb *PromptBetter) RephraseTitle(title, excerpt string) (string, error) {
+	return pb.run("rephrase-title", pbRephraseTitlePayload{
+		Title:   title,
+		Excerpt: excerpt,
+	})
+}
+
+// Topics the LLM can assign to an article, used for community routing.
+var Topics = []string{"papers", "tools", "tutorials", "industry news", "open-source releases"}
+
+type pbClassifyTopicPayload struct {
+	Title   string   `json:"title"`
+	Excerpt string   `json:"excerpt"`
+	Topics  []string `json:"topics"`
+}
+
+// ClassifyTopic returns one of Topics, or an empty string if the LLM did not
+// answer with a known topic.
+func (pb *PromptBetter) ClassifyTopic(title, excerpt string) (string, error) {
+	answer, err := pb.run("classify-topic", pbClassifyTopicPayload{
+		Title:   title,
+		Excerpt: excerpt,
+		Topics:  Topics,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	for _, topic := range Topics {
+		if strings.Contains(answer, topic) {
+			return topic, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,113 @@
+package rss
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"newsbots/pkg/posts"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var feedLinkTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// Paths tried on the site if the page does not link its feeds
+var commonFeedPaths = []string{"/feed", "/feed/", "/rss", "/rss.xml", "/atom.xml", "/feed.xml", "/index.xml", "/feed.json", "/blog/rss.xml", "/blog/feed"}
+
+type DiscoveredFeed struct {
+	URL   string
+	Posts posts.Posts
+}
+
+// Discover finds the feeds of a website. It looks for <link rel="alternate">
+// feed links in the page and tries the common feed paths. Only urls which
+// parse as RSS, Atom or JSON Feed are returned.
+func Discover(siteURL string) ([]DiscoveredFeed, error) {
+	base, err := url.Parse(siteURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse site url: %w", err)
+	}
+	if base.Scheme == "" {
+		base, err = url.Parse("https://" + siteURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse site url: %w", err)
+		}
+	}
+
+	candidates := make([]string, 0)
+	linked, pageErr := linkedFeeds(base)
+	if pageErr == nil {
+		candidates = append(candidates, linked...)
+	}
+	for _, p := range commonFeedPaths {
+		candidates = append(candidates, base.ResolveReference(&url.URL{Path: p}).String())
+	}
+
+	feeds := make([]DiscoveredFeed, 0)
+	tried := make(map[string]bool)
+	for _, candidate := range candidates {
+		if tried[candidate] {
+			continue
+		}
+		tried[candidate] = true
+
+		rssPosts, err := GetPostsFromRSS(candidate)
+		if err != nil {
+			continue
+		}
+		feeds = append(feeds, DiscoveredFeed{
+			URL:   candidate,
+			Posts: rssPosts,
+		})
+		// Both paths often serve the same feed
+		tried[strings.TrimSuffix(candidate, "/")] = true
+		tried[strings.TrimSuffix(candidate, "/")+"/"] = true
+	}
+
+	if len(feeds) == 0 && pageErr != nil {
+		return nil, fmt.Errorf("could not load site: %w", pageErr)
+	}
+	return feeds, nil
+}
+
+func linkedFeeds(base *url.URL) ([]string, error) {
+	res, err := http.Get(base.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not http get page: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status not 200, but %d", res.StatusCode)
+	}
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could NewDocumentFromReader: %w", err)
+	}
+
+	// Relative links resolve against the final url after redirects
+	pageURL := res.Request.URL
+	feedURLs := make([]string, 0)
+	doc.Find(`link[rel="alternate"]`).Each(func(i int, s *goquery.Selection) {
+		linkType, _ := s.Attr("type")
+		href, _ := s.Attr("href")
+		if href == "" {
+			return
+		}
+		for _, t := range feedLinkTypes {
+			if strings.EqualFold(strings.TrimSpace(linkType), t) {
+				u, err := pageURL.Parse(strings.TrimSpace(href))
+				if err == nil {
+					feedURLs = append(feedURLs, u.String())
+				}
+				return
+			}
+		}
+	})
+	return feedURLs, nil
+}
@@ -0,0 +1,154 @@
+package rss
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"newsbots/pkg/store"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// After this many failures in a row the circuit breaker opens and the feed is
+// only probed again after BreakerBaseDelay, doubled with every further
+// failure up to BreakerMaxDelay.
+var (
+	BreakerThreshold = 3
+	BreakerBaseDelay = 30 * time.Minute
+	BreakerMaxDelay  = 24 * time.Hour
+	// Number of item urls remembered per feed to count the new items
+	maxSeenItems = 1000
+)
+
+// Health is the fetch history of a feed.
+type Health struct {
+	Feed                string    `json:"feed"`
+	FirstFetch          time.Time `json:"first_fetch"`
+	LastAttempt         time.Time `json:"last_attempt"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastStatus          int       `json:"last_status"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	// NextProbe is set while the circuit breaker is open
+	NextProbe time.Time `json:"next_probe,omitempty"`
+	NewItems  int       `json:"new_items"`
+	Accepted  int       `json:"accepted"`
+	SeenItems []uint64  `json:"seen_items,omitempty"`
+}
+
+var healths = store.Bucket[Health]{Prefix: store.FeedHealthPrefix}
+
+// LoadHealth returns the stored health of the feed, or a new one.
+func LoadHealth(db *store.Store, feedURL string) (*Health, error) {
+	h, err := healths.Load(db, feedURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not get feed health from db: %w", err)
+	}
+	if h == nil {
+		h = &Health{Feed: feedURL}
+	}
+	return h, nil
+}
+
+// ListHealth returns the health of all feeds which were fetched before.
+func ListHealth(db *store.Store) (map[string]*Health, error) {
+	all, err := healths.All(db)
+	if err != nil {
+		return nil, fmt.Errorf("could not list feed health: %w", err)
+	}
+	byFeed := make(map[string]*Health, len(all))
+	for _, h := range all {
+		byFeed[h.Feed] = h
+	}
+	return byFeed, nil
+}
+
+func (h *Health) Save(db *store.Store) error {
+	return healths.Save(db, h.Feed, h)
+}
+
+// Allow reports if the feed may be fetched. It is false while the circuit
+// breaker is open and the next probe is not due yet.
+func (h *Health) Allow(now time.Time) bool {
+	return h.NextProbe.IsZero() || !now.Before(h.NextProbe)
+}
+
+// RecordSuccess closes the circuit breaker and counts the items not seen in
+// earlier fetches.
+func (h *Health) RecordSuccess(itemURLs []string, now time.Time) {
+	h.recordAttempt(now)
+	h.LastSuccess = now
+	h.LastError = ""
+	h.LastStatus = 200
+	h.ConsecutiveFailures = 0
+	h.NextProbe = time.Time{}
+
+	seen := make(map[uint64]bool, len(h.SeenItems))
+	for _, s := range h.SeenItems {
+		seen[s] = true
+	}
+	for _, itemURL := range itemURLs {
+		hash := fnv.New64a()
+		hash.Write([]byte(itemURL))
+		sum := hash.Sum64()
+		if seen[sum] {
+			continue
+		}
+		seen[sum] = true
+		h.NewItems++
+		h.SeenItems = append(h.SeenItems, sum)
+	}
+	if len(h.SeenItems) > maxSeenItems {
+		h.SeenItems = h.SeenItems[len(h.SeenItems)-maxSeenItems:]
+	}
+}
+
+// RecordFailure counts the failure and opens the circuit breaker after
+// BreakerThreshold failures in a row.
+func (h *Health) RecordFailure(err error, now time.Time) {
+	h.recordAttempt(now)
+	h.LastError = err.Error()
+	h.LastStatus = 0
+	httpErr := gofeed.HTTPError{}
+	if errors.As(err, &httpErr) {
+		h.LastStatus = httpErr.StatusCode
+	}
+	h.ConsecutiveFailures++
+
+	if h.ConsecutiveFailures < BreakerThreshold {
+		return
+	}
+	delay := BreakerBaseDelay
+	for i := BreakerThreshold; i < h.ConsecutiveFailures && delay < BreakerMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > BreakerMaxDelay {
+		delay = BreakerMaxDelay
+	}
+	h.NextProbe = now.Add(delay)
+}
+
+func (h *Health) recordAttempt(now time.Time) {
+	if h.FirstFetch.IsZero() {
+		h.FirstFetch = now
+	}
+	h.LastAttempt = now
+}
+
+// ItemsPerDay returns the new items per day since the first fetch.
+func (h *Health) ItemsPerDay(now time.Time) float64 {
+	days := now.Sub(h.FirstFetch).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	return float64(h.NewItems) / days
+}
+
+// AcceptanceRate returns the share of new items which got posted.
+func (h *Health) AcceptanceRate() float64 {
+	if h.NewItems == 0 {
+		return 0
+	}
+	return float64(h.Accepted) / float64(h.NewItems)
+}
@@ -19,6 +19,7 @@ func GetPostsFromRSS(rssFeed string) (posts.Posts, error) {
 		rssPosts = append(rssPosts, posts.Post{
 			Title: strings.TrimSpace(i.Title),
 			Url:   strings.TrimSpace(i.Link),
+			Feed:  rssFeed,
 		})
 	}
 
@@ -0,0 +1,38 @@
+package rss
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// Offset returns the fixed position of the feed inside its poll interval.
+// It is derived from the feed url, so feeds with the same interval spread
+// evenly across the hour instead of all running at the full hour.
+func Offset(feedURL string, pollEvery time.Duration) time.Duration {
+	if pollEvery <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(feedURL))
+	return time.Duration(h.Sum64() % uint64(pollEvery))
+}
+
+// NextRun returns when the feed is due next. Slots are aligned to the unix
+// epoch plus the feed offset, so the schedule does not drift between runs.
+func NextRun(feedURL string, pollEvery time.Duration, lastFetch, now time.Time) time.Time {
+	if pollEvery <= 0 {
+		return now
+	}
+	offset := Offset(feedURL, pollEvery)
+	sinceEpoch := now.Sub(time.Unix(0, 0)) - offset
+	slotStart := time.Unix(0, 0).Add(offset + sinceEpoch - sinceEpoch%pollEvery)
+	if lastFetch.Before(slotStart) {
+		return slotStart
+	}
+	return slotStart.Add(pollEvery)
+}
+
+// IsDue reports if the feed was not fetched in its current slot yet.
+func IsDue(feedURL string, pollEvery time.Duration, lastFetch, now time.Time) bool {
+	return !NextRun(feedURL, pollEvery, lastFetch, now).After(now)
+}
@@ -0,0 +1,172 @@
+package publish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/store"
+	"os"
+	"time"
+)
+
+// Config sets how fast prepared posts are released. Zero values mean no
+// limit.
+type Config struct {
+	PerHour int `json:"per_hour"`
+	// MinGap is the minimum time between two posts, like "10m"
+	MinGap string `json:"min_gap"`
+	// QuietHours like "23:00" to "06:00" release nothing
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	// Timezone of the quiet hours, like "Europe/Berlin". Default UTC.
+	Timezone           string `json:"timezone"`
+	PerCommunityPerDay int    `json:"per_community_per_day"`
+}
+
+// Scheduler decides if a post may be published now, from the posts
+// published in the last 24 hours.
+type Scheduler struct {
+	db         *store.Store
+	config     Config
+	minGap     time.Duration
+	location   *time.Location
+	quietStart time.Duration
+	quietEnd   time.Duration
+	published  []published
+}
+
+type published struct {
+	time        time.Time
+	communityID int
+}
+
+// LoadScheduler reads the config file. Without a file nothing is limited.
+func LoadScheduler(db *store.Store, configPath string) (*Scheduler, error) {
+	config := Config{}
+	configJSON, err := os.ReadFile(configPath)
+	if err == nil {
+		err = json.Unmarshal(configJSON, &config)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal publish config: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read publish config: %w", err)
+	}
+	return NewScheduler(db, config)
+}
+
+func NewScheduler(db *store.Store, config Config) (*Scheduler, error) {
+	s := &Scheduler{
+		db:       db,
+		config:   config,
+		location: time.UTC,
+	}
+
+	var err error
+	if config.MinGap != "" {
+		s.minGap, err = time.ParseDuration(config.MinGap)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse min_gap: %w", err)
+		}
+	}
+	if config.Timezone != "" {
+		s.location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("could not load timezone: %w", err)
+		}
+	}
+	if (config.QuietStart == "") != (config.QuietEnd == "") {
+		return nil, fmt.Errorf("quiet hours need quiet_start and quiet_end")
+	}
+	if config.QuietStart != "" {
+		s.quietStart, err = parseClock(config.QuietStart)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse quiet_start: %w", err)
+		}
+		s.quietEnd, err = parseClock(config.QuietEnd)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse quiet_end: %w", err)
+		}
+	}
+
+	err = s.load(clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseClock parses "23:30" to the time since midnight.
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// load reads the posts of the last 24 hours, older entries are deleted.
+func (s *Scheduler) load(now time.Time) error {
+	log, err := s.db.PublishLog(now.Add(-24 * time.Hour))
+	if err != nil {
+		return err
+	}
+	for _, p := range log {
+		s.published = append(s.published, published{time: p.Time, communityID: p.CommunityID})
+	}
+	return nil
+}
+
+// Allow reports if a post to the community may be published now, and the
+// reason if not.
+func (s *Scheduler) Allow(communityID int, now time.Time) (bool, string) {
+	if s.quiet(now) {
+		return false, "quiet hours"
+	}
+
+	lastHour := 0
+	communityDay := 0
+	var last time.Time
+	for _, p := range s.published {
+		if now.Sub(p.time) < time.Hour {
+			lastHour++
+		}
+		if p.communityID == communityID && now.Sub(p.time) < 24*time.Hour {
+			communityDay++
+		}
+		if p.time.After(last) {
+			last = p.time
+		}
+	}
+
+	if s.minGap > 0 && !last.IsZero() && now.Sub(last) < s.minGap {
+		return false, "min gap"
+	}
+	if s.config.PerHour > 0 && lastHour >= s.config.PerHour {
+		return false, "per hour limit"
+	}
+	if s.config.PerCommunityPerDay > 0 && communityDay >= s.config.PerCommunityPerDay {
+		return false, "community cap"
+	}
+	return true, ""
+}
+
+func (s *Scheduler) quiet(now time.Time) bool {
+	if s.config.QuietStart == "" {
+		return false
+	}
+	local := now.In(s.location)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if s.quietStart <= s.quietEnd {
+		return sinceMidnight >= s.quietStart && sinceMidnight < s.quietEnd
+	}
+	// Over midnight, like 23:00 to 06:00
+	return sinceMidnight >= s.quietStart || sinceMidnight < s.quietEnd
+}
+
+// Record adds a published post to the log.
+func (s *Scheduler) Record(communityID int, now time.Time) error {
+	s.published = append(s.published, published{time: now, communityID: communityID})
+	return s.db.LogPublish(store.Published{Time: now, CommunityID: communityID})
+}
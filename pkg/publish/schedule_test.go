@@ -0,0 +1,180 @@
+package publish
+
+import (
+	"newsbots/pkg/clock"
+	"newsbots/pkg/store"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+func TestAllow(t *testing.T) {
+	type post struct {
+		ago         time.Duration
+		communityID int
+	}
+	tests := []struct {
+		name        string
+		config      Config
+		published   []post
+		now         time.Time
+		communityID int
+		wantReason  string
+	}{
+		{
+			name:      "no limits",
+			published: []post{{time.Second, 4}, {time.Second, 4}},
+		},
+		{
+			name:       "quiet over midnight",
+			config:     Config{QuietStart: "23:00", QuietEnd: "06:00"},
+			now:        time.Date(2024, 5, 6, 2, 0, 0, 0, time.UTC),
+			wantReason: "quiet hours",
+		},
+		{
+			name:       "quiet starts",
+			config:     Config{QuietStart: "23:00", QuietEnd: "06:00"},
+			now:        time.Date(2024, 5, 6, 23, 0, 0, 0, time.UTC),
+			wantReason: "quiet hours",
+		},
+		{
+			name:   "quiet ended",
+			config: Config{QuietStart: "23:00", QuietEnd: "06:00"},
+			now:    time.Date(2024, 5, 6, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "quiet within the day",
+			config:     Config{QuietStart: "12:00", QuietEnd: "13:00"},
+			now:        time.Date(2024, 5, 6, 12, 30, 0, 0, time.UTC),
+			wantReason: "quiet hours",
+		},
+		{
+			name:       "quiet in the timezone",
+			config:     Config{QuietStart: "00:00", QuietEnd: "06:00", Timezone: "Europe/Berlin"},
+			now:        time.Date(2024, 5, 6, 23, 30, 0, 0, time.UTC),
+			wantReason: "quiet hours",
+		},
+		{
+			name:       "min gap",
+			config:     Config{MinGap: "10m"},
+			published:  []post{{5 * time.Minute, 4}},
+			wantReason: "min gap",
+		},
+		{
+			name:      "min gap passed",
+			config:    Config{MinGap: "10m"},
+			published: []post{{15 * time.Minute, 4}},
+		},
+		{
+			name:       "per hour",
+			config:     Config{PerHour: 2},
+			published:  []post{{10 * time.Minute, 4}, {50 * time.Minute, 7}, {2 * time.Hour, 4}},
+			wantReason: "per hour limit",
+		},
+		{
+			name:      "per hour passed",
+			config:    Config{PerHour: 2},
+			published: []post{{10 * time.Minute, 4}, {61 * time.Minute, 7}},
+		},
+		{
+			name:        "community cap",
+			config:      Config{PerCommunityPerDay: 2},
+			published:   []post{{time.Hour, 4}, {20 * time.Hour, 4}, {25 * time.Hour, 4}},
+			communityID: 4,
+			wantReason:  "community cap",
+		},
+		{
+			name:        "other community",
+			config:      Config{PerCommunityPerDay: 2},
+			published:   []post{{time.Hour, 4}, {20 * time.Hour, 4}},
+			communityID: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := tt.now
+			if now.IsZero() {
+				now = testNow
+			}
+			communityID := tt.communityID
+			if communityID == 0 {
+				communityID = 4
+			}
+
+			// The published posts come from the db, like in the next run
+			db := store.OpenMemory()
+			for _, p := range tt.published {
+				err := db.LogPublish(store.Published{Time: now.Add(-p.ago), CommunityID: p.communityID})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			previous := clock.Now
+			clock.Freeze(now)
+			defer func() {
+				clock.Now = previous
+			}()
+			s, err := NewScheduler(db, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, reason := s.Allow(communityID, now)
+			if ok != (tt.wantReason == "") || reason != tt.wantReason {
+				t.Errorf("Allow = %v %q, want reason %q", ok, reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := store.OpenMemory()
+	s, err := NewScheduler(db, Config{PerHour: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if ok, _ := s.Allow(4, now); !ok {
+		t.Fatalf("first post not allowed")
+	}
+	err = s.Record(4, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Allow(4, now); ok {
+		t.Errorf("second post allowed")
+	}
+
+	// A new scheduler reads the post from the db
+	s, err = NewScheduler(db, Config{PerHour: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Allow(4, now); ok {
+		t.Errorf("second post allowed by a new scheduler")
+	}
+}
+
+func TestNewSchedulerConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"empty", Config{}, false},
+		{"quiet hours", Config{QuietStart: "23:00", QuietEnd: "06:00"}, false},
+		{"quiet start only", Config{QuietStart: "23:00"}, true},
+		{"invalid clock", Config{QuietStart: "25:00", QuietEnd: "06:00"}, true},
+		{"invalid min gap", Config{MinGap: "ten minutes"}, true},
+		{"invalid timezone", Config{Timezone: "Mars/Olympus"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScheduler(store.OpenMemory(), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/posts"
+	"newsbots/pkg/store"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	pending = store.Bucket[Item]{Prefix: store.QueuePrefix}
+	dead    = store.Bucket[Item]{Prefix: store.DeadLetterPrefix}
+)
+
+// A failed publish is retried after BaseDelay, doubled with every further
+// attempt up to MaxDelay. After MaxAttempts the item is dead-lettered.
+var (
+	BaseDelay   = 2 * time.Minute
+	MaxDelay    = 2 * time.Hour
+	MaxAttempts = 8
+)
+
+// ErrPermanent marks publish errors which no retry can fix, like a
+// duplicate post. The item is dead-lettered right away.
+var ErrPermanent = errors.New("permanent error")
+
+// Item is a fully prepared post waiting to be published.
+type Item struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Body        string    `json:"body"`
+	Account     string    `json:"account"`
+	Feed        string    `json:"feed"`
+	Topic       string    `json:"topic,omitempty"`
+	CommunityID int       `json:"community_id"`
+	Priority    int       `json:"priority,omitempty"`
+	SourceText  string    `json:"source_text,omitempty"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+func itemID(url string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(url))
+	return strconv.FormatUint(uint64(hash.Sum32()), 16)
+}
+
+// Post returns the post to publish.
+func (i *Item) Post() posts.Post {
+	return posts.Post{
+		Title:       i.Title,
+		Url:         i.URL,
+		Description: i.Body,
+		Account:     i.Account,
+		Feed:        i.Feed,
+		Topic:       i.Topic,
+		CommunityID: i.CommunityID,
+		Priority:    i.Priority,
+		SourceText:  i.SourceText,
+	}
+}
+
+// Enqueue adds the prepared post to the queue. It is due right away.
+func Enqueue(db *store.Store, p posts.Post) error {
+	now := clock.Now().UTC()
+	item := &Item{
+		ID:          itemID(p.Url),
+		Title:       p.Title,
+		URL:         p.Url,
+		Body:        p.Description,
+		Account:     p.Account,
+		Feed:        p.Feed,
+		Topic:       p.Topic,
+		CommunityID: p.CommunityID,
+		Priority:    p.Priority,
+		SourceText:  p.SourceText,
+		Created:     now,
+		NextAttempt: now,
+	}
+	return pending.Save(db, item.URL, item)
+}
+
+// Contains reports if the url waits in the queue or is dead-lettered.
+func Contains(db *store.Store, url string) (bool, error) {
+	found := false
+	err := db.View(func(tx *store.Tx) error {
+		for _, bucket := range []store.Bucket[Item]{pending, dead} {
+			item, err := bucket.Get(tx, url)
+			if err != nil {
+				return err
+			}
+			if item != nil {
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not get queue item from db: %w", err)
+	}
+	return found, nil
+}
+
+// Pending returns the items waiting to be published, oldest first.
+func Pending(db *store.Store) ([]*Item, error) {
+	return list(db, pending)
+}
+
+// Dead returns the dead-lettered items, oldest first.
+func Dead(db *store.Store) ([]*Item, error) {
+	return list(db, dead)
+}
+
+func list(db *store.Store, bucket store.Bucket[Item]) ([]*Item, error) {
+	items, err := bucket.All(db)
+	if err != nil {
+		return nil, fmt.Errorf("could not list queue: %w", err)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Created.Before(items[j].Created)
+	})
+	return items, nil
+}
+
+// move stores the item in the new bucket and deletes it from the old one
+// in a single transaction. A nil to only deletes.
+func move(db *store.Store, from store.Bucket[Item], to *store.Bucket[Item], item *Item) error {
+	return db.Update(func(tx *store.Tx) error {
+		err := from.Delete(tx, item.URL)
+		if err != nil {
+			return err
+		}
+		if to == nil {
+			return nil
+		}
+		return to.Put(tx, item.URL, item)
+	})
+}
+
+// Drain publishes the due items, highest priority first and oldest first
+// within a priority. Items which allow rejects stay in the queue untouched,
+// a nil allow releases everything. Failed items are retried with backoff,
+// and dead-lettered after MaxAttempts or a permanent error. Returns the
+// number of published items.
+func Drain(ctx context.Context, db *store.Store, allow func(item *Item) bool, publish func(p posts.Post) error) (int, error) {
+	items, err := Pending(db)
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Priority > items[j].Priority
+	})
+
+	published := 0
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		now := clock.Now().UTC()
+		if now.Before(item.NextAttempt) {
+			continue
+		}
+		if allow != nil && !allow(item) {
+			continue
+		}
+
+		item.Attempts++
+		err := publish(item.Post())
+		if err == nil {
+			err = move(db, pending, nil, item)
+			if err != nil {
+				return published, err
+			}
+			published++
+			continue
+		}
+
+		item.LastError = err.Error()
+		logger := slog.With("feed", item.Feed, "item", posts.CanonicalURL(item.URL), "stage", "publish", "attempts", item.Attempts)
+		if errors.Is(err, ErrPermanent) || item.Attempts >= MaxAttempts {
+			logger.Error("dead-lettered", "error", err)
+			err = move(db, pending, &dead, item)
+		} else {
+			item.NextAttempt = now.Add(backoff(item.Attempts))
+			logger.Warn("publish failed, retry later", "next_attempt", item.NextAttempt, "error", err)
+			err = pending.Save(db, item.URL, item)
+		}
+		if err != nil {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+func backoff(attempts int) time.Duration {
+	delay := BaseDelay
+	for i := 1; i < attempts && delay < MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxDelay {
+		delay = MaxDelay
+	}
+	return delay
+}
+
+// find returns the item with the ID or url in the bucket.
+func find(db *store.Store, bucket store.Bucket[Item], idOrURL string) (*Item, error) {
+	items, err := list(db, bucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.ID == idOrURL || item.URL == idOrURL {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// Retry moves a dead-lettered item back into the queue, due right away.
+func Retry(db *store.Store, idOrURL string) error {
+	item, err := find(db, dead, idOrURL)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("dead-lettered item %q not found", idOrURL)
+	}
+	item.Attempts = 0
+	item.NextAttempt = clock.Now().UTC()
+	return move(db, dead, &pending, item)
+}
+
+// Drop deletes the item from the queue or the dead letters.
+func Drop(db *store.Store, idOrURL string) error {
+	for _, bucket := range []store.Bucket[Item]{pending, dead} {
+		item, err := find(db, bucket, idOrURL)
+		if err != nil {
+			return err
+		}
+		if item != nil {
+			return move(db, bucket, nil, item)
+		}
+	}
+	return fmt.Errorf("queue item %q not found", idOrURL)
+}
@@ -0,0 +1,235 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"newsbots/pkg/clock"
+	"newsbots/pkg/posts"
+	"newsbots/pkg/store"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+
+// setClock freezes the clock at the time until the end of the test.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+	previous := clock.Now
+	clock.Freeze(now)
+	t.Cleanup(func() {
+		clock.Now = previous
+	})
+}
+
+// enqueue adds posts with the urls, one minute apart.
+func enqueue(t *testing.T, db *store.Store, urls ...string) {
+	t.Helper()
+	for k, url := range urls {
+		setClock(t, testStart.Add(time.Duration(k-len(urls))*time.Minute))
+		err := Enqueue(db, posts.Post{Url: url, Title: "Title of " + url})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	setClock(t, testStart)
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 8 * time.Minute},
+		{6, 64 * time.Minute},
+		{7, 2 * time.Hour},
+		{20, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name          string
+		publishErr    error
+		allow         func(item *Item) bool
+		wantPublished int
+		wantPending   int
+		wantDead      int
+		wantAttempts  int
+		wantNext      time.Time
+	}{
+		{
+			name:          "published",
+			wantPublished: 1,
+		},
+		{
+			name:         "retried later",
+			publishErr:   errFailed,
+			wantPending:  1,
+			wantAttempts: 1,
+			wantNext:     testStart.Add(BaseDelay),
+		},
+		{
+			name:       "permanent error",
+			publishErr: fmt.Errorf("%w: banned", ErrPermanent),
+			wantDead:   1,
+			// Dead-lettered right away
+			wantAttempts: 1,
+		},
+		{
+			name: "held back",
+			allow: func(item *Item) bool {
+				return false
+			},
+			wantPending: 1,
+			wantNext:    testStart.Add(-time.Minute),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := store.OpenMemory()
+			enqueue(t, db, "https://example.com/a")
+
+			calls := 0
+			published, err := Drain(context.Background(), db, tt.allow, func(p posts.Post) error {
+				calls++
+				if p.Url != "https://example.com/a" || p.Title != "Title of https://example.com/a" {
+					t.Errorf("publish got %+v", p)
+				}
+				return tt.publishErr
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if published != tt.wantPublished {
+				t.Errorf("published %d, want %d", published, tt.wantPublished)
+			}
+
+			pendingItems, err := Pending(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deadItems, err := Dead(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pendingItems) != tt.wantPending || len(deadItems) != tt.wantDead {
+				t.Fatalf("%d pending and %d dead, want %d and %d", len(pendingItems), len(deadItems), tt.wantPending, tt.wantDead)
+			}
+			items := append(pendingItems, deadItems...)
+			if len(items) == 0 {
+				return
+			}
+			item := items[0]
+			if item.Attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", item.Attempts, tt.wantAttempts)
+			}
+			if tt.publishErr != nil && item.LastError != tt.publishErr.Error() {
+				t.Errorf("last error %q, want %q", item.LastError, tt.publishErr)
+			}
+			if tt.wantPending > 0 && !item.NextAttempt.Equal(tt.wantNext) {
+				t.Errorf("next attempt %s, want %s", item.NextAttempt, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestDrainBackoffUntilDead(t *testing.T) {
+	db := store.OpenMemory()
+	enqueue(t, db, "https://example.com/a")
+
+	now := testStart
+	calls := 0
+	failing := func(p posts.Post) error {
+		calls++
+		return errors.New("failed")
+	}
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		_, err := Drain(context.Background(), db, nil, failing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != attempt {
+			t.Fatalf("%d publish calls after attempt %d", calls, attempt)
+		}
+
+		// Not due before the backoff passed
+		setClock(t, now.Add(backoff(attempt)-time.Second))
+		_, err = Drain(context.Background(), db, nil, failing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != attempt {
+			t.Fatalf("item published before its backoff passed after attempt %d", attempt)
+		}
+
+		now = now.Add(backoff(attempt))
+		setClock(t, now)
+	}
+
+	pendingItems, err := Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadItems, err := Dead(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingItems) != 0 || len(deadItems) != 1 {
+		t.Fatalf("%d pending and %d dead, want the item dead-lettered", len(pendingItems), len(deadItems))
+	}
+	if deadItems[0].Attempts != MaxAttempts {
+		t.Errorf("dead-lettered after %d attempts, want %d", deadItems[0].Attempts, MaxAttempts)
+	}
+
+	// A retry makes it due right away
+	err = Retry(db, "https://example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := Drain(context.Background(), db, nil, func(p posts.Post) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published != 1 {
+		t.Errorf("published %d after Retry, want 1", published)
+	}
+}
+
+func TestDrainOrder(t *testing.T) {
+	db := store.OpenMemory()
+	enqueue(t, db, "https://example.com/old", "https://example.com/new", "https://example.com/important")
+	important, err := pending.Load(db, "https://example.com/important")
+	if err != nil {
+		t.Fatal(err)
+	}
+	important.Priority = 1
+	err = pending.Save(db, important.URL, important)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make([]string, 0)
+	_, err = Drain(context.Background(), db, nil, func(p posts.Post) error {
+		order = append(order, p.Url)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[https://example.com/important https://example.com/old https://example.com/new]"
+	if fmt.Sprint(order) != want {
+		t.Errorf("published %v, want %s", order, want)
+	}
+}
@@ -0,0 +1,208 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"newsbots/pkg/metrics"
+	"newsbots/pkg/store"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the summary of one run: how many items each feed produced, how
+// many each stage dropped, what was posted and which errors happened.
+type Report struct {
+	RunID               string         `json:"run_id"`
+	Command             string         `json:"command"`
+	Start               time.Time      `json:"start"`
+	End                 time.Time      `json:"end"`
+	Feeds               []*FeedFunnel  `json:"feeds"`
+	Errors              map[string]int `json:"errors"`
+	LLMCalls            int            `json:"llm_calls"`
+	ClassifierDecisions int            `json:"classifier_decisions"`
+
+	llmCallsAtStart            float64
+	classifierDecisionsAtStart float64
+}
+
+type FeedFunnel struct {
+	Feed   string       `json:"feed"`
+	Stages []StageCount `json:"stages"`
+}
+
+type StageCount struct {
+	Stage string `json:"stage"`
+	In    int    `json:"in"`
+	Out   int    `json:"out"`
+}
+
+func New(runID, command string) *Report {
+	return &Report{
+		RunID:                      runID,
+		Command:                    command,
+		Start:                      time.Now(),
+		Errors:                     make(map[string]int),
+		llmCallsAtStart:            metrics.Default.Sum("newsbots_llm_calls_total"),
+		classifierDecisionsAtStart: metrics.Default.Sum("newsbots_classifier_decisions_total"),
+	}
+}
+
+// Stage adds the items going in and out of a stage of the feed.
+func (r *Report) Stage(feed, stage string, in, out int) {
+	if r == nil {
+		return
+	}
+	f := r.feed(feed)
+	for k := range f.Stages {
+		if f.Stages[k].Stage == stage {
+			f.Stages[k].In += in
+			f.Stages[k].Out += out
+			return
+		}
+	}
+	f.Stages = append(f.Stages, StageCount{Stage: stage, In: in, Out: out})
+}
+
+// Error counts an error of the given type, like "NewPost".
+func (r *Report) Error(errorType string) {
+	if r == nil {
+		return
+	}
+	r.Errors[errorType]++
+}
+
+// Finish sets the end of the run and the LLM usage since the start.
+func (r *Report) Finish() {
+	r.End = time.Now()
+	r.LLMCalls = int(metrics.Default.Sum("newsbots_llm_calls_total") - r.llmCallsAtStart)
+	r.ClassifierDecisions = int(metrics.Default.Sum("newsbots_classifier_decisions_total") - r.classifierDecisionsAtStart)
+}
+
+func (r *Report) Duration() time.Duration {
+	return r.End.Sub(r.Start).Round(time.Second)
+}
+
+func (r *Report) feed(feed string) *FeedFunnel {
+	for _, f := range r.Feeds {
+		if f.Feed == feed {
+			return f
+		}
+	}
+	f := &FeedFunnel{Feed: feed}
+	r.Feeds = append(r.Feeds, f)
+	return f
+}
+
+// Count returns how many items left the stage, summed over all feeds.
+func (r *Report) Count(stage string) int {
+	count := 0
+	for _, f := range r.Feeds {
+		count += f.Count(stage)
+	}
+	return count
+}
+
+func (r *Report) ErrorCount() int {
+	count := 0
+	for _, c := range r.Errors {
+		count += c
+	}
+	return count
+}
+
+func (f *FeedFunnel) Count(stage string) int {
+	for _, s := range f.Stages {
+		if s.Stage == stage {
+			return s.Out
+		}
+	}
+	return 0
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteTable writes the funnel of every feed as a plain text table.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run %s (%s) %s, took %s\n", r.RunID, r.Command, r.Start.Format(time.RFC3339), r.Duration())
+	fmt.Fprintf(tw, "LLM calls: %d, classifier decisions: %d\n\n", r.LLMCalls, r.ClassifierDecisions)
+	fmt.Fprintln(tw, "FEED\tSTAGE\tIN\tOUT\tDROPPED")
+	for _, f := range r.Feeds {
+		for _, s := range f.Stages {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", f.Feed, s.Stage, s.In, s.Out, s.In-s.Out)
+		}
+	}
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(tw, "\nERROR\tCOUNT")
+		for _, errorType := range r.errorTypes() {
+			fmt.Fprintf(tw, "%s\t%d\n", errorType, r.Errors[errorType])
+		}
+	}
+	return tw.Flush()
+}
+
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Run %s (%s)\n\n", r.RunID, r.Command)
+	fmt.Fprintf(&b, "- Start: %s\n- Duration: %s\n- LLM calls: %d\n- Classifier decisions: %d\n\n",
+		r.Start.Format(time.RFC3339), r.Duration(), r.LLMCalls, r.ClassifierDecisions)
+	b.WriteString("## Feeds\n\n| Feed | Stage | In | Out | Dropped |\n|---|---|---:|---:|---:|\n")
+	for _, f := range r.Feeds {
+		for _, s := range f.Stages {
+			fmt.Fprintf(&b, "| %s | %s | %d | %d | %d |\n", f.Feed, s.Stage, s.In, s.Out, s.In-s.Out)
+		}
+	}
+	if len(r.Errors) > 0 {
+		b.WriteString("\n## Errors\n\n| Error | Count |\n|---|---:|\n")
+		for _, errorType := range r.errorTypes() {
+			fmt.Fprintf(&b, "| %s | %d |\n", errorType, r.Errors[errorType])
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r *Report) errorTypes() []string {
+	errorTypes := make([]string, 0, len(r.Errors))
+	for errorType := range r.Errors {
+		errorTypes = append(errorTypes, errorType)
+	}
+	sort.Strings(errorTypes)
+	return errorTypes
+}
+
+var runs = store.Bucket[Report]{Prefix: store.RunPrefix}
+
+// Save stores the report in the db, keyed by its start time.
+func (r *Report) Save(db *store.Store) error {
+	return runs.Save(db, store.TimeKey(r.Start), r)
+}
+
+// List returns the latest reports, newest first.
+func List(db *store.Store, limit int) ([]*Report, error) {
+	reports := make([]*Report, 0, limit+1)
+	err := db.View(func(tx *store.Tx) error {
+		// Keys are in time order, keep the last ones
+		return runs.Each(tx, func(id string, r *Report) error {
+			reports = append(reports, r)
+			if len(reports) > limit {
+				reports = reports[1:]
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list reports: %w", err)
+	}
+	for i, j := 0, len(reports)-1; i < j; i, j = i+1, j-1 {
+		reports[i], reports[j] = reports[j], reports[i]
+	}
+	return reports, nil
+}
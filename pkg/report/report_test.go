@@ -0,0 +1,34 @@
+package report
+
+import (
+	"newsbots/pkg/store"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	db := store.OpenMemory()
+	defer db.Close()
+	// Whole seconds sorted after their fractions in RFC 3339 keys
+	start := time.Date(2024, 5, 6, 12, 0, 5, 0, time.UTC)
+	for k, offset := range []time.Duration{100 * time.Millisecond, 0, time.Second, 10 * time.Millisecond} {
+		r := New(string(rune('a'+k)), "rss")
+		r.Start = start.Add(offset)
+		err := r.Save(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reports, err := List(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ""
+	for _, r := range reports {
+		got += r.RunID
+	}
+	if got != "cad" {
+		t.Errorf("listed runs %q, want the newest three first \"cad\"", got)
+	}
+}
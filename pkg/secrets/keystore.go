@@ -0,0 +1,147 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Keystore is a local file with secrets, encrypted with AES-256-GCM.
+type Keystore struct {
+	path   string
+	aead   cipher.AEAD
+	values map[string]string
+}
+
+type keystoreFile struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// ParseKey reads a 32 byte key given as hex or base64.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key is neither hex nor base64")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key has %d bytes, expect 32", len(key))
+	}
+	return key, nil
+}
+
+// OpenKeystore decrypts the keystore at the path. A missing file is an empty
+// keystore, which is created on Save.
+func OpenKeystore(keystorePath string, key []byte) (*Keystore, error) {
+	aead, err := NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keystore{
+		path:   keystorePath,
+		aead:   aead,
+		values: make(map[string]string),
+	}
+
+	content, err := os.ReadFile(keystorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read keystore: %w", err)
+	}
+	file := keystoreFile{}
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal keystore: %w", err)
+	}
+	data, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt keystore, wrong key? %w", err)
+	}
+	err = json.Unmarshal(data, &k.values)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal keystore values: %w", err)
+	}
+	return k, nil
+}
+
+// NewAEAD returns AES-256-GCM for the 32 byte key.
+func NewAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gcm: %w", err)
+	}
+	return aead, nil
+}
+
+func (k *Keystore) Get(name string) (string, error) {
+	value, found := k.values[name]
+	if !found {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (k *Keystore) Set(name, value string) {
+	k.values[name] = value
+}
+
+func (k *Keystore) Delete(name string) {
+	delete(k.values, name)
+}
+
+// Names returns the names of all secrets, sorted.
+func (k *Keystore) Names() []string {
+	names := make([]string, 0, len(k.values))
+	for name := range k.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the keystore with a new nonce and replaces the file.
+func (k *Keystore) Save() error {
+	data, err := json.Marshal(k.values)
+	if err != nil {
+		return fmt.Errorf("could not marshal keystore values: %w", err)
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("could not create nonce: %w", err)
+	}
+	content, err := json.Marshal(keystoreFile{
+		Nonce: nonce,
+		Data:  k.aead.Seal(nil, nonce, data, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal keystore: %w", err)
+	}
+
+	tmpPath := k.path + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write keystore: %w", err)
+	}
+	err = os.Rename(tmpPath, k.path)
+	if err != nil {
+		return fmt.Errorf("could not replace keystore: %w", err)
+	}
+	return nil
+}
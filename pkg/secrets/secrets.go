@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Provider returns secrets by name, like "PROMPTBETTER_TOKEN". Missing
+// secrets return ErrNotFound.
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+// Env reads the secret from the environment variable of the same name, or
+// from the file named in NAME_FILE, as Docker and Kubernetes mount them.
+type Env struct{}
+
+func (Env) Get(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	secretPath := os.Getenv(name + "_FILE")
+	if secretPath == "" {
+		return "", ErrNotFound
+	}
+	return readSecretFile(secretPath)
+}
+
+// Dir reads the secret from a file of the same name in the directory, like
+// /run/secrets/PROMPTBETTER_TOKEN. The lowercase name is tried as well.
+type Dir struct {
+	Path string
+}
+
+func (d Dir) Get(name string) (string, error) {
+	for _, fileName := range []string{name, strings.ToLower(name)} {
+		value, err := readSecretFile(filepath.Join(d.Path, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrNotFound
+}
+
+func readSecretFile(secretPath string) (string, error) {
+	value, err := os.ReadFile(secretPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file: %w", err)
+	}
+	// Editors and echo add a newline
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+// Chain asks the providers in order and returns the first secret found.
+type Chain []Provider
+
+func (c Chain) Get(name string) (string, error) {
+	for _, p := range c {
+		value, err := p.Get(name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not get secret %s: %w", name, err)
+		}
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+// Require returns the secrets by name, or an error naming all missing ones.
+func Require(p Provider, names ...string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	missing := make([]string, 0)
+	for _, name := range names {
+		value, err := p.Get(name)
+		if errors.Is(err, ErrNotFound) || (err == nil && value == "") {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required secrets: %s", strings.Join(missing, ", "))
+	}
+	return values, nil
+}
@@ -0,0 +1,13 @@
+package store
+
+import "fmt"
+
+// LegacyAccount reports if the account was registered before the account
+// store and has no credential.
+func (tx *Tx) LegacyAccount(username string) (bool, error) {
+	legacy, err := tx.has(LegacyAccountPrefix + username)
+	if err != nil {
+		return false, fmt.Errorf("could not get from db: %w", err)
+	}
+	return legacy, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Open opens the badger db in the dir.
+func Open(dir string) (*Store, error) {
+	opts := badger.DefaultOptions(dir).WithLogger(badgerLogger{})
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not badger open db: %w", err)
+	}
+	return New(&badgerBackend{db: db}), nil
+}
+
+type badgerBackend struct {
+	db *badger.DB
+}
+
+func (b *badgerBackend) View(fn func(txn Txn) error) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		return fn(badgerTxn{txn: txn})
+	})
+}
+
+func (b *badgerBackend) Update(fn func(txn Txn) error) error {
+	txn := b.db.NewTransaction(true)
+	defer txn.Discard()
+
+	err := fn(badgerTxn{txn: txn})
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction and check for error.
+	if err := txn.Commit(); err != nil {
+		return fmt.Errorf("could not commit to db: %w", err)
+	}
+	return nil
+}
+
+func (b *badgerBackend) Close() error {
+	return b.db.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t badgerTxn) Get(key string) ([]byte, error) {
+	item, err := t.txn.Get([]byte(key))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t badgerTxn) Set(key string, value []byte) error {
+	return t.txn.Set([]byte(key), value)
+}
+
+func (t badgerTxn) Delete(key string) error {
+	return t.txn.Delete([]byte(key))
+}
+
+func (t badgerTxn) Scan(prefix string, fn func(key string, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	it := t.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		err := item.Value(func(val []byte) error {
+			return fn(key, val)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badgerLogger hands the badger logs to slog.
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(format string, args ...interface{}) {
+	slog.Error(strings.TrimSpace(fmt.Sprintf(format, args...)), "component", "badger")
+}
+
+func (badgerLogger) Warningf(format string, args ...interface{}) {
+	slog.Warn(strings.TrimSpace(fmt.Sprintf(format, args...)), "component", "badger")
+}
+
+func (badgerLogger) Infof(format string, args ...interface{}) {
+	slog.Debug(strings.TrimSpace(fmt.Sprintf(format, args...)), "component", "badger")
+}
+
+func (badgerLogger) Debugf(format string, args ...interface{}) {
+	slog.Debug(strings.TrimSpace(fmt.Sprintf(format, args...)), "component", "badger")
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Bucket stores values of type T as JSON under the keys with Prefix. The
+// methods taking a Tx work inside a transaction, the others in their own.
+type Bucket[T any] struct {
+	Prefix string
+}
+
+// Get returns the value, or nil if there is none.
+func (b Bucket[T]) Get(tx *Tx, id string) (*T, error) {
+	value, err := tx.txn.Get(b.Prefix + id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get from db: %w", err)
+	}
+	v := new(T)
+	err = json.Unmarshal(value, v)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal %s%s: %w", b.Prefix, id, err)
+	}
+	return v, nil
+}
+
+func (b Bucket[T]) Put(tx *Tx, id string, v *T) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s%s: %w", b.Prefix, id, err)
+	}
+	err = tx.txn.Set(b.Prefix+id, value)
+	if err != nil {
+		return fmt.Errorf("could not set to db: %w", err)
+	}
+	return nil
+}
+
+func (b Bucket[T]) Delete(tx *Tx, id string) error {
+	err := tx.txn.Delete(b.Prefix + id)
+	if err != nil {
+		return fmt.Errorf("could not delete from db: %w", err)
+	}
+	return nil
+}
+
+// Each calls fn for every value in key order.
+func (b Bucket[T]) Each(tx *Tx, fn func(id string, v *T) error) error {
+	return tx.txn.Scan(b.Prefix, func(key string, value []byte) error {
+		v := new(T)
+		err := json.Unmarshal(value, v)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal %s: %w", key, err)
+		}
+		return fn(key[len(b.Prefix):], v)
+	})
+}
+
+// List returns all values in key order.
+func (b Bucket[T]) List(tx *Tx) ([]*T, error) {
+	values := make([]*T, 0)
+	err := b.Each(tx, func(id string, v *T) error {
+		values = append(values, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Load returns the value, or nil if there is none.
+func (b Bucket[T]) Load(s *Store, id string) (*T, error) {
+	var v *T
+	err := s.View(func(tx *Tx) error {
+		var err error
+		v, err = b.Get(tx, id)
+		return err
+	})
+	return v, err
+}
+
+func (b Bucket[T]) Save(s *Store, id string, v *T) error {
+	return s.Update(func(tx *Tx) error {
+		return b.Put(tx, id, v)
+	})
+}
+
+func (b Bucket[T]) Remove(s *Store, id string) error {
+	return s.Update(func(tx *Tx) error {
+		return b.Delete(tx, id)
+	})
+}
+
+// All returns all values in key order.
+func (b Bucket[T]) All(s *Store) ([]*T, error) {
+	var values []*T
+	err := s.View(func(tx *Tx) error {
+		var err error
+		values, err = b.List(tx)
+		return err
+	})
+	return values, err
+}
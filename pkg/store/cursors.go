@@ -0,0 +1,72 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// HNCursor returns the newest Hacker News story ID seen, or -1.
+func (s *Store) HNCursor() (int, error) {
+	cursor := -1
+	err := s.View(func(tx *Tx) error {
+		value, err := tx.txn.Get(HNCursorKey)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cursor, err = strconv.Atoi(string(value))
+		return err
+	})
+	if err != nil {
+		return -1, fmt.Errorf("could not get HN cursor from db: %w", err)
+	}
+	return cursor, nil
+}
+
+func (s *Store) SetHNCursor(storyID int) error {
+	return s.Update(func(tx *Tx) error {
+		err := tx.txn.Set(HNCursorKey, []byte(strconv.Itoa(storyID)))
+		if err != nil {
+			return fmt.Errorf("could not set to db: %w", err)
+		}
+		return nil
+	})
+}
+
+// LastFetch returns the time of the last fetch of the feed, or the zero time
+// if it was never fetched.
+func (s *Store) LastFetch(feedURL string) (time.Time, error) {
+	lastFetch := time.Time{}
+	err := s.View(func(tx *Tx) error {
+		value, err := tx.txn.Get(FeedFetchPrefix + feedURL)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return lastFetch.UnmarshalText(value)
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not get last fetch from db: %w", err)
+	}
+	return lastFetch, nil
+}
+
+func (s *Store) SetLastFetch(feedURL string, lastFetch time.Time) error {
+	value, err := lastFetch.MarshalText()
+	if err != nil {
+		return fmt.Errorf("could not marshal time: %w", err)
+	}
+	return s.Update(func(tx *Tx) error {
+		err := tx.txn.Set(FeedFetchPrefix+feedURL, value)
+		if err != nil {
+			return fmt.Errorf("could not set to db: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// restoreBatch is the number of keys written per transaction on restore.
+const restoreBatch = 1000
+
+// DumpEntry is a line of a dump. Values which are not UTF-8 are kept in
+// ValueBase64.
+type DumpEntry struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ValueBase64 string `json:"value_base64,omitempty"`
+}
+
+// Dump writes all keys as JSON lines, in key order, and returns their
+// number.
+func (s *Store) Dump(w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	n := 0
+	err := s.View(func(tx *Tx) error {
+		return tx.txn.Scan("", func(key string, value []byte) error {
+			e := DumpEntry{Key: key}
+			if utf8.Valid(value) {
+				e.Value = string(value)
+			} else {
+				e.ValueBase64 = base64.StdEncoding.EncodeToString(value)
+			}
+			n++
+			return encoder.Encode(e)
+		})
+	})
+	if err != nil {
+		return n, fmt.Errorf("could not dump db: %w", err)
+	}
+	return n, nil
+}
+
+// Restore writes the keys of a dump to an empty db and returns their
+// number. Run Migrate afterwards for a dump of an older schema.
+func (s *Store) Restore(r io.Reader) (int, error) {
+	empty, err := s.empty()
+	if err != nil {
+		return 0, err
+	}
+	if !empty {
+		return 0, fmt.Errorf("db is not empty, restore into a new db")
+	}
+
+	decoder := json.NewDecoder(r)
+	n := 0
+	batch := make([]entry, 0, restoreBatch)
+	for {
+		e := DumpEntry{}
+		err = decoder.Decode(&e)
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("could not decode entry %d of dump: %w", n+len(batch)+1, err)
+		}
+		if err == nil {
+			value := []byte(e.Value)
+			if e.ValueBase64 != "" {
+				value, err = base64.StdEncoding.DecodeString(e.ValueBase64)
+				if err != nil {
+					return n, fmt.Errorf("could not decode value of %s: %w", e.Key, err)
+				}
+			}
+			batch = append(batch, entry{key: e.Key, value: value})
+		}
+
+		if len(batch) == restoreBatch || (err == io.EOF && len(batch) > 0) {
+			writeErr := s.Update(func(tx *Tx) error {
+				for _, e := range batch {
+					err := tx.txn.Set(e.key, e.value)
+					if err != nil {
+						return fmt.Errorf("could not set to db: %w", err)
+					}
+				}
+				return nil
+			})
+			if writeErr != nil {
+				return n, writeErr
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Key prefixes of the store. Every key is a namespace, the version of its
+// layout and an ID, mostly an url. A change of a layout bumps its version
+// and SchemaVersion, with a migration in migrate.go.
+const (
+	// SeenPrefix marks urls which are posted
+	SeenPrefix = "seen/v1/"
+	// VerdictPrefix holds the negative verdicts of the LLM until they expire
+	VerdictPrefix = "verdict/v1/"
+	// ExamplePrefix holds the labelled examples of the classifier
+	ExamplePrefix = "example/v1/"
+	// AccountPrefix holds the credentials of the bot accounts
+	AccountPrefix = "account/v1/"
+	// LegacyAccountPrefix marks accounts from before the credentials, which
+	// still have the username plus suffix as password
+	LegacyAccountPrefix = "legacyaccount/v1/"
+	// FeedConfigPrefix and FeedHistoryPrefix hold the feed configs and
+	// their changes, by unix nano time
+	FeedConfigPrefix  = "feedconfig/v1/"
+	FeedHistoryPrefix = "feedhistory/v1/"
+	// FeedFetchPrefix holds the time of the last fetch of a feed
+	FeedFetchPrefix = "feedfetch/v1/"
+	// FeedHealthPrefix holds the fetch history of a feed
+	FeedHealthPrefix = "feedhealth/v1/"
+	// QueuePrefix and DeadLetterPrefix hold the prepared posts
+	QueuePrefix      = "queue/v1/"
+	DeadLetterPrefix = "deadletter/v1/"
+	// PublishLogPrefix holds the community of every post, by unix nano time
+	PublishLogPrefix = "publishlog/v1/"
+	// RunPrefix holds the run reports, by unix nano start time
+	RunPrefix = "run/v2/"
+)
+
+const (
+	// HNCursorKey holds the newest Hacker News story ID seen.
+	HNCursorKey = "cursor/v1/hn"
+	// SchemaKey holds the schema version of the db.
+	SchemaKey = "meta/schema"
+	// FeedsSeededKey marks that the feeds were seeded from rss_feeds.json.
+	FeedsSeededKey = "meta/feedsseeded"
+)
+
+// TimeKey returns the time as zero padded unix nano time, which sorts like
+// the time.
+func TimeKey(t time.Time) string {
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errReadOnly is returned for changes in a View.
+var errReadOnly = errors.New("read-only transaction")
+
+// OpenMemory opens an empty store which lives in memory only.
+func OpenMemory() *Store {
+	return New(&memoryBackend{data: make(map[string][]byte)})
+}
+
+type memoryBackend struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+func (m *memoryBackend) View(fn func(txn Txn) error) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return fn(&memoryTxn{backend: m})
+}
+
+// Update collects the changes and applies them only if fn succeeds.
+func (m *memoryBackend) Update(fn func(txn Txn) error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	txn := &memoryTxn{backend: m, writable: true, changes: make(map[string][]byte)}
+	err := fn(txn)
+	if err != nil {
+		return err
+	}
+	for key, value := range txn.changes {
+		if value == nil {
+			delete(m.data, key)
+		} else {
+			m.data[key] = value
+		}
+	}
+	return nil
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+// memoryTxn sees its own changes. A nil value in changes is a deletion.
+type memoryTxn struct {
+	backend  *memoryBackend
+	writable bool
+	changes  map[string][]byte
+}
+
+func (t *memoryTxn) Get(key string) ([]byte, error) {
+	value, changed := t.changes[key]
+	if !changed {
+		value = t.backend.data[key]
+	}
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (t *memoryTxn) Set(key string, value []byte) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	t.changes[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTxn) Delete(key string) error {
+	if !t.writable {
+		return errReadOnly
+	}
+	t.changes[key] = nil
+	return nil
+}
+
+func (t *memoryTxn) Scan(prefix string, fn func(key string, value []byte) error) error {
+	keys := make([]string, 0)
+	for key := range t.backend.data {
+		if _, changed := t.changes[key]; !changed && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key, value := range t.changes {
+		if value != nil && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, _ := t.Get(key)
+		err := fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package store
+
+import "fmt"
+
+// FeedsSeeded reports if the feed configs were seeded once. Feeds removed
+// later stay removed.
+func (s *Store) FeedsSeeded() (bool, error) {
+	seeded := false
+	err := s.View(func(tx *Tx) error {
+		var err error
+		seeded, err = tx.has(FeedsSeededKey)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not get from db: %w", err)
+	}
+	return seeded, nil
+}
+
+func (s *Store) SetFeedsSeeded() error {
+	return s.Update(func(tx *Tx) error {
+		return tx.setFeedsSeeded()
+	})
+}
+
+func (tx *Tx) setFeedsSeeded() error {
+	err := tx.txn.Set(FeedsSeededKey, []byte("1"))
+	if err != nil {
+		return fmt.Errorf("could not set to db: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the key layout in keys.go. A db without
+// a schema key is version 0, the layout from before the versions.
+const SchemaVersion = 2
+
+// ErrSchemaOutdated is returned by CheckSchema for a db which needs a
+// migration.
+var ErrSchemaOutdated = errors.New("db schema outdated, run 'newsbots db migrate'")
+
+// migrateBatch is the number of keys rewritten per transaction, to stay
+// below the transaction size limit of badger.
+const migrateBatch = 1000
+
+// migration migrates from a version to the next one.
+type migration struct {
+	// rename rewrites a key of the previous version. It returns an empty
+	// newKey to keep the key as it is, like keys of the new version left by
+	// an interrupted migration.
+	rename func(key string, value []byte) (newKey string, newValue []byte)
+	// finish runs after all keys are renamed, if set
+	finish func(tx *Tx) error
+}
+
+// migrations[v] migrates from version v to v+1.
+var migrations = []migration{
+	{rename: migrateV0, finish: finishV0},
+	{rename: migrateV1},
+}
+
+// runV1Prefix held the run reports by RFC 3339 start time until version 2.
+// RFC 3339 drops the trailing zeros of the fraction, so the keys did not
+// sort like the times.
+const runV1Prefix = "run/v1/"
+
+// v0Prefixes maps the key prefixes of version 0 to the ones of version 1.
+var v0Prefixes = map[string]string{
+	"post+":        SeenPrefix,
+	"example+":     ExamplePrefix,
+	"account+":     AccountPrefix,
+	"feedconfig+":  FeedConfigPrefix,
+	"feedhistory+": FeedHistoryPrefix,
+	"feedfetch+":   FeedFetchPrefix,
+	"feedhealth+":  FeedHealthPrefix,
+	"queue+":       QueuePrefix,
+	"deadletter+":  DeadLetterPrefix,
+	"publishlog+":  PublishLogPrefix,
+	"run+":         runV1Prefix,
+}
+
+// migrateV0 moves the keys to namespaced, versioned prefixes.
+func migrateV0(key string, value []byte) (string, []byte) {
+	if key == "lastHNID" {
+		return HNCursorKey, value
+	}
+	if oldPrefix, id, ok := strings.Cut(key, "+"); ok {
+		if newPrefix, ok := v0Prefixes[oldPrefix+"+"]; ok {
+			if newPrefix == PublishLogPrefix {
+				id = publishLogID(id)
+			}
+			return newPrefix + id, value
+		}
+	}
+	// The legacy accounts are bare usernames, like "3blue1brown" or "two
+	// minute papers"
+	if !strings.ContainsAny(key, "/+") {
+		return LegacyAccountPrefix + key, value
+	}
+	return "", nil
+}
+
+// migrateV1 keys the run reports by unix nano start time. Unparsable times
+// keep their ID.
+func migrateV1(key string, value []byte) (string, []byte) {
+	id, ok := strings.CutPrefix(key, runV1Prefix)
+	if !ok {
+		return "", nil
+	}
+	if start, err := time.Parse(time.RFC3339Nano, id); err == nil {
+		id = TimeKey(start)
+	}
+	return RunPrefix + id, value
+}
+
+// finishV0 marks the feeds of a db from before the seed marker as seeded,
+// so they are not seeded again.
+func finishV0(tx *Tx) error {
+	seeded := false
+	err := tx.txn.Scan(FeedConfigPrefix, func(key string, value []byte) error {
+		seeded = true
+		return errStop
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return fmt.Errorf("could not scan db: %w", err)
+	}
+	if !seeded {
+		return nil
+	}
+	return tx.setFeedsSeeded()
+}
+
+// Schema returns the schema version of the db.
+func (s *Store) Schema() (int, error) {
+	version := 0
+	err := s.View(func(tx *Tx) error {
+		value, err := tx.txn.Get(SchemaKey)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		version, err = strconv.Atoi(string(value))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get schema version from db: %w", err)
+	}
+	return version, nil
+}
+
+func (s *Store) setSchema(version int) error {
+	return s.Update(func(tx *Tx) error {
+		err := tx.txn.Set(SchemaKey, []byte(strconv.Itoa(version)))
+		if err != nil {
+			return fmt.Errorf("could not set to db: %w", err)
+		}
+		return nil
+	})
+}
+
+// CheckSchema returns ErrSchemaOutdated if the db needs a migration. A new
+// db gets the current schema version.
+func (s *Store) CheckSchema() error {
+	version, err := s.Schema()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("db schema %d is newer than %d, update newsbots", version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	empty, err := s.empty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("%w: version %d, want %d", ErrSchemaOutdated, version, SchemaVersion)
+	}
+	return s.setSchema(SchemaVersion)
+}
+
+// Migrate rewrites the keys of older schema versions in place and returns
+// the number of rewritten keys. An interrupted migration is finished by the
+// next one.
+func (s *Store) Migrate() (int, error) {
+	version, err := s.Schema()
+	if err != nil {
+		return 0, err
+	}
+	if version > SchemaVersion {
+		return 0, fmt.Errorf("db schema %d is newer than %d, update newsbots", version, SchemaVersion)
+	}
+
+	rewritten := 0
+	for ; version < SchemaVersion; version++ {
+		m := migrations[version]
+		n, err := s.rewrite(m.rename)
+		rewritten += n
+		if err == nil && m.finish != nil {
+			err = s.Update(m.finish)
+		}
+		if err != nil {
+			return rewritten, fmt.Errorf("could not migrate schema %d to %d: %w", version, version+1, err)
+		}
+		err = s.setSchema(version + 1)
+		if err != nil {
+			return rewritten, err
+		}
+		slog.Info("migrated db schema", "from", version, "to", version+1, "keys", n)
+	}
+	return rewritten, nil
+}
+
+type entry struct {
+	key   string
+	value []byte
+}
+
+// rewrite renames all keys, in batches.
+func (s *Store) rewrite(rename func(key string, value []byte) (string, []byte)) (int, error) {
+	old := make([]entry, 0)
+	renamed := make([]entry, 0)
+	err := s.View(func(tx *Tx) error {
+		return tx.txn.Scan("", func(key string, value []byte) error {
+			if key == SchemaKey {
+				return nil
+			}
+			newKey, newValue := rename(key, value)
+			if newKey == "" {
+				return nil
+			}
+			old = append(old, entry{key: key})
+			renamed = append(renamed, entry{key: newKey, value: append([]byte(nil), newValue...)})
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not scan db: %w", err)
+	}
+
+	for start := 0; start < len(old); start += migrateBatch {
+		end := min(start+migrateBatch, len(old))
+		err = s.Update(func(tx *Tx) error {
+			for k := start; k < end; k++ {
+				if old[k].key != renamed[k].key {
+					err := tx.txn.Delete(old[k].key)
+					if err != nil {
+						return fmt.Errorf("could not delete from db: %w", err)
+					}
+				}
+				err := tx.txn.Set(renamed[k].key, renamed[k].value)
+				if err != nil {
+					return fmt.Errorf("could not set to db: %w", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return start, err
+		}
+	}
+	return len(old), nil
+}
+
+// errStop ends a Scan early.
+var errStop = errors.New("stop")
+
+// empty reports if the db has no keys.
+func (s *Store) empty() (bool, error) {
+	empty := true
+	err := s.View(func(tx *Tx) error {
+		return tx.txn.Scan("", func(key string, value []byte) error {
+			empty = false
+			return errStop
+		})
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return false, fmt.Errorf("could not scan db: %w", err)
+	}
+	return empty, nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateV0(t *testing.T) {
+	published := time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	tests := []struct {
+		key     string
+		wantKey string
+	}{
+		{"post+https://example.com/a", SeenPrefix + "https://example.com/a"},
+		{"account+kaggle", AccountPrefix + "kaggle"},
+		{"lastHNID", HNCursorKey},
+		{"publishlog+" + published.Format(time.RFC3339Nano), PublishLogPrefix + "1714979289123000000"},
+		{"publishlog+yesterday", PublishLogPrefix + "yesterday"},
+		{"run+2024-05-06T07:08:09Z", runV1Prefix + "2024-05-06T07:08:09Z"},
+		// Legacy accounts are every other key without a separator
+		{"openaicom_blog", LegacyAccountPrefix + "openaicom_blog"},
+		{"Kaggle", LegacyAccountPrefix + "Kaggle"},
+		{"MIT", LegacyAccountPrefix + "MIT"},
+		{"3Blue1Brown", LegacyAccountPrefix + "3Blue1Brown"},
+		{"two minute papers", LegacyAccountPrefix + "two minute papers"},
+		{"Arxiv Insights", LegacyAccountPrefix + "Arxiv Insights"},
+		// Keys of version 1, left by an interrupted migration, stay
+		{SeenPrefix + "https://example.com/b", ""},
+		{LegacyAccountPrefix + "kaggle", ""},
+		{"unknown+key", ""},
+	}
+	for _, tt := range tests {
+		newKey, _ := migrateV0(tt.key, []byte("value"))
+		if newKey != tt.wantKey {
+			t.Errorf("migrateV0(%q) = %q, want %q", tt.key, newKey, tt.wantKey)
+		}
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantKey string
+	}{
+		{runV1Prefix + "2024-05-06T07:08:09.123Z", RunPrefix + "1714979289123000000"},
+		{runV1Prefix + "2024-05-06T09:08:09+02:00", RunPrefix + "1714979289000000000"},
+		{runV1Prefix + "run-1", RunPrefix + "run-1"},
+		{RunPrefix + "1714979289000000000", ""},
+		{SeenPrefix + "https://example.com/a", ""},
+	}
+	for _, tt := range tests {
+		newKey, _ := migrateV1(tt.key, []byte("value"))
+		if newKey != tt.wantKey {
+			t.Errorf("migrateV1(%q) = %q, want %q", tt.key, newKey, tt.wantKey)
+		}
+	}
+}
+
+func TestMigratePublishLog(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		// RFC 3339 drops trailing zeros of the fraction, so its keys do not
+		// sort like the times
+		now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+		times := []time.Time{
+			now.Add(-2 * time.Hour),
+			now.Add(-time.Hour),
+			now.Add(-time.Hour + 500*time.Millisecond),
+			now.Add(-30 * time.Hour),
+		}
+		err := s.Update(func(tx *Tx) error {
+			for k, published := range times {
+				err := tx.txn.Set("publishlog+"+published.Format(time.RFC3339Nano), []byte{byte('1' + k)})
+				if err != nil {
+					return err
+				}
+			}
+			return tx.txn.Set("Kaggle", []byte("token"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.Migrate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.CheckSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		log, err := s.PublishLog(now.Add(-24 * time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Published{{times[0], 1}, {times[1], 2}, {times[2], 3}}
+		if len(log) != len(want) {
+			t.Fatalf("got %d entries, want %d: %v", len(log), len(want), log)
+		}
+		for k := range want {
+			if !log[k].Time.Equal(want[k].Time) || log[k].CommunityID != want[k].CommunityID {
+				t.Errorf("entry %d is %v, want %v", k, log[k], want[k])
+			}
+		}
+
+		err = s.View(func(tx *Tx) error {
+			legacy, err := tx.LegacyAccount("Kaggle")
+			if err == nil && !legacy {
+				t.Errorf("Kaggle is no legacy account")
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
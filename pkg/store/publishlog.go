@@ -0,0 +1,73 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Published is an entry of the publish log.
+type Published struct {
+	Time        time.Time
+	CommunityID int
+}
+
+// PublishLog returns the posts published since the time, oldest first, and
+// deletes the older entries.
+func (s *Store) PublishLog(since time.Time) ([]Published, error) {
+	log := make([]Published, 0)
+	err := s.Update(func(tx *Tx) error {
+		expired := make([]string, 0)
+		err := tx.txn.Scan(PublishLogPrefix, func(key string, value []byte) error {
+			nanos, err := strconv.ParseInt(strings.TrimPrefix(key, PublishLogPrefix), 10, 64)
+			t := time.Unix(0, nanos).UTC()
+			if err != nil || t.Before(since) {
+				expired = append(expired, key)
+				return nil
+			}
+			communityID, err := strconv.Atoi(string(value))
+			if err != nil {
+				return fmt.Errorf("could not parse community of %s: %w", key, err)
+			}
+			log = append(log, Published{Time: t, CommunityID: communityID})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			err = tx.txn.Delete(key)
+			if err != nil {
+				return fmt.Errorf("could not delete from db: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read publish log: %w", err)
+	}
+	return log, nil
+}
+
+// LogPublish adds a post to the community to the publish log.
+func (s *Store) LogPublish(p Published) error {
+	return s.Update(func(tx *Tx) error {
+		err := tx.txn.Set(PublishLogPrefix+TimeKey(p.Time), []byte(strconv.Itoa(p.CommunityID)))
+		if err != nil {
+			return fmt.Errorf("could not set to db: %w", err)
+		}
+		return nil
+	})
+}
+
+// publishLogID converts the RFC 3339 time of a version 0 key to unix nano
+// time, which sorts like the time. Unparsable times are kept, PublishLog
+// drops them.
+func publishLogID(id string) string {
+	t, err := time.Parse(time.RFC3339Nano, id)
+	if err != nil {
+		return id
+	}
+	return TimeKey(t)
+}
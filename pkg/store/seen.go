@@ -0,0 +1,74 @@
+package store
+
+import "fmt"
+
+// Seen reports if the url was posted before.
+func (tx *Tx) Seen(url string) (bool, error) {
+	seen, err := tx.has(SeenPrefix + url)
+	if err != nil {
+		return false, fmt.Errorf("could not get from db: %w", err)
+	}
+	return seen, nil
+}
+
+// MarkSeen marks the url as posted.
+func (tx *Tx) MarkSeen(url string) error {
+	err := tx.txn.Set(SeenPrefix+url, []byte(url))
+	if err != nil {
+		return fmt.Errorf("could not set to db: %w", err)
+	}
+	return nil
+}
+
+// Seen reports if the url was posted before.
+func (s *Store) Seen(url string) (bool, error) {
+	seen := false
+	err := s.View(func(tx *Tx) error {
+		var err error
+		seen, err = tx.Seen(url)
+		return err
+	})
+	return seen, err
+}
+
+// MarkSeen marks the urls as posted, all at once.
+func (s *Store) MarkSeen(urls ...string) error {
+	return s.Update(func(tx *Tx) error {
+		for _, url := range urls {
+			err := tx.MarkSeen(url)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteSeen deletes the seen urls which match and returns their number.
+func (s *Store) DeleteSeen(match func(url string) bool) (int, error) {
+	n := 0
+	err := s.Update(func(tx *Tx) error {
+		matched := make([]string, 0)
+		err := tx.txn.Scan(SeenPrefix, func(key string, value []byte) error {
+			if match(key[len(SeenPrefix):]) {
+				matched = append(matched, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range matched {
+			err = tx.txn.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(matched)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not delete seen urls: %w", err)
+	}
+	return n, nil
+}
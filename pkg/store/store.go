@@ -0,0 +1,72 @@
+// Package store keeps the state of the bots: seen urls, verdicts, classifier
+// examples, accounts, cursors, the queue, feeds and run history. The key
+// layout lives in keys.go, the data in a Backend, badger on disk or a map in
+// memory.
+package store
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by Txn.Get for a missing key.
+var ErrNotFound = errors.New("key not found")
+
+// Backend is a key-value store with transactions.
+type Backend interface {
+	View(fn func(txn Txn) error) error
+	// Update commits the changes of fn if it returns nil, else discards
+	// them.
+	Update(fn func(txn Txn) error) error
+	Close() error
+}
+
+// Txn is a transaction of a Backend.
+type Txn interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	// Scan calls fn for every key with the prefix, in key order. The value
+	// is only valid inside fn.
+	Scan(prefix string, fn func(key string, value []byte) error) error
+}
+
+type Store struct {
+	backend Backend
+}
+
+func New(backend Backend) *Store {
+	return &Store{backend: backend}
+}
+
+// Tx is a transaction with the typed methods of the store.
+type Tx struct {
+	txn Txn
+}
+
+func (s *Store) View(fn func(tx *Tx) error) error {
+	return s.backend.View(func(txn Txn) error {
+		return fn(&Tx{txn: txn})
+	})
+}
+
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	return s.backend.Update(func(txn Txn) error {
+		return fn(&Tx{txn: txn})
+	})
+}
+
+func (s *Store) Close() error {
+	return s.backend.Close()
+}
+
+// has reports if the key exists.
+func (tx *Tx) has(key string) (bool, error) {
+	_, err := tx.txn.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,310 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testBackends open an empty store of every backend.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) *Store
+}{
+	{"memory", func(t *testing.T) *Store {
+		return OpenMemory()
+	}},
+	{"badger", func(t *testing.T) *Store {
+		s, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}},
+}
+
+// forEachBackend runs the test on an empty store of every backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, s *Store)) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			s := b.open(t)
+			defer s.Close()
+			test(t, s)
+		})
+	}
+}
+
+func TestSeen(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		err := s.MarkSeen("https://example.com/a", "https://example.com/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			url  string
+			want bool
+		}{
+			{"https://example.com/a", true},
+			{"https://example.com/b", true},
+			{"https://example.com/c", false},
+			{"https://example.com/", false},
+			{"https://example.com/a/", false},
+		}
+		for _, tt := range tests {
+			seen, err := s.Seen(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen != tt.want {
+				t.Errorf("Seen(%q) = %v, want %v", tt.url, seen, tt.want)
+			}
+		}
+	})
+}
+
+type testValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestBucket(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		bucket := Bucket[testValue]{Prefix: "test/v1/"}
+		// Keys of a bucket with a longer prefix are not in the bucket
+		other := Bucket[testValue]{Prefix: "test/v10/"}
+
+		for _, id := range []string{"b", "a", "c"} {
+			err := bucket.Save(s, id, &testValue{Name: id, Count: len(id)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := other.Save(s, "x", &testValue{Name: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			id   string
+			want *testValue
+		}{
+			{"a", &testValue{Name: "a", Count: 1}},
+			{"c", &testValue{Name: "c", Count: 1}},
+			{"x", nil},
+			{"missing", nil},
+		}
+		for _, tt := range tests {
+			v, err := bucket.Load(s, tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(v) != fmt.Sprint(tt.want) {
+				t.Errorf("Load(%q) = %v, want %v", tt.id, v, tt.want)
+			}
+		}
+
+		ids := func() string {
+			t.Helper()
+			got := make([]string, 0)
+			err := s.View(func(tx *Tx) error {
+				return bucket.Each(tx, func(id string, v *testValue) error {
+					if v.Name != id {
+						return fmt.Errorf("value %q under id %q", v.Name, id)
+					}
+					got = append(got, id)
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fmt.Sprint(got)
+		}
+		if got := ids(); got != "[a b c]" {
+			t.Errorf("Each ids %s, want [a b c]", got)
+		}
+
+		err = bucket.Remove(s, "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(); got != "[a c]" {
+			t.Errorf("Each ids after Remove %s, want [a c]", got)
+		}
+		all, err := bucket.All(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || all[0].Name != "a" || all[1].Name != "c" {
+			t.Errorf("All = %v, want a and c", all)
+		}
+	})
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		// change runs in the transaction of the scan
+		change func(tx *Tx) error
+		want   string
+	}{
+		{
+			name:   "key order",
+			prefix: "scan/",
+			want:   "[scan/a/10 scan/a/2 scan/b scan/c]",
+		},
+		{
+			name:   "prefix",
+			prefix: "scan/a/",
+			want:   "[scan/a/10 scan/a/2]",
+		},
+		{
+			name:   "no match",
+			prefix: "none/",
+			want:   "[]",
+		},
+		{
+			name:   "own changes",
+			prefix: "scan/",
+			change: func(tx *Tx) error {
+				err := tx.txn.Delete("scan/b")
+				if err != nil {
+					return err
+				}
+				return tx.txn.Set("scan/a/3", []byte("new"))
+			},
+			want: "[scan/a/10 scan/a/2 scan/a/3 scan/c]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, s *Store) {
+				err := s.Update(func(tx *Tx) error {
+					for _, key := range []string{"scan/c", "scan/a/2", "scan", "scan/b", "scan/a/10", "scao/a"} {
+						err := tx.txn.Set(key, []byte(key))
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				keys := make([]string, 0)
+				err = s.Update(func(tx *Tx) error {
+					if tt.change != nil {
+						err := tt.change(tx)
+						if err != nil {
+							return err
+						}
+					}
+					return tx.txn.Scan(tt.prefix, func(key string, value []byte) error {
+						keys = append(keys, key)
+						return nil
+					})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(keys) != tt.want {
+					t.Errorf("Scan(%q) = %v, want %s", tt.prefix, keys, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestScanStops(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		err := s.MarkSeen("a", "b", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errFound := errors.New("found")
+		keys := 0
+		err = s.View(func(tx *Tx) error {
+			return tx.txn.Scan(SeenPrefix, func(key string, value []byte) error {
+				keys++
+				if key == SeenPrefix+"b" {
+					return errFound
+				}
+				return nil
+			})
+		})
+		if !errors.Is(err, errFound) {
+			t.Errorf("got error %v, want the error of fn", err)
+		}
+		if keys != 2 {
+			t.Errorf("scanned %d keys, want 2", keys)
+		}
+	})
+}
+
+func TestViewRejectsWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(tx *Tx) error
+	}{
+		{"set", func(tx *Tx) error {
+			return tx.MarkSeen("https://example.com/new")
+		}},
+		{"delete", func(tx *Tx) error {
+			return tx.txn.Delete(SeenPrefix + "https://example.com/old")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachBackend(t, func(t *testing.T, s *Store) {
+				err := s.MarkSeen("https://example.com/old")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = s.View(tt.write)
+				if err == nil {
+					t.Errorf("write in a View succeeded")
+				}
+
+				for url, want := range map[string]bool{
+					"https://example.com/new": false,
+					"https://example.com/old": true,
+				} {
+					seen, err := s.Seen(url)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if seen != want {
+						t.Errorf("Seen(%q) = %v after the View, want %v", url, seen, want)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestUpdateDiscardsOnError(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		errFailed := errors.New("failed")
+		err := s.Update(func(tx *Tx) error {
+			err := tx.MarkSeen("https://example.com/a")
+			if err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Errorf("got error %v, want the error of fn", err)
+		}
+		seen, err := s.Seen("https://example.com/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen {
+			t.Errorf("change of a failed Update was kept")
+		}
+	})
+}
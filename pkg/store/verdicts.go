@@ -0,0 +1,77 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Verdict is a negative verdict of the LLM about an url. It keeps the url
+// from being checked again until it expires or the prompt changes.
+type Verdict struct {
+	URL           string    `json:"url"`
+	Score         int       `json:"score"`
+	Reason        string    `json:"reason,omitempty"`
+	PromptVersion string    `json:"prompt_version"`
+	Created       time.Time `json:"created"`
+	Expires       time.Time `json:"expires"`
+}
+
+// Valid reports if the verdict still holds at the time, for the version of
+// the prompt.
+func (v *Verdict) Valid(now time.Time, promptVersion string) bool {
+	return v.PromptVersion == promptVersion && now.Before(v.Expires)
+}
+
+var verdicts = Bucket[Verdict]{Prefix: VerdictPrefix}
+
+// Verdict returns the verdict about the url, or nil if there is none. The
+// verdict may be outdated, check Valid.
+func (s *Store) Verdict(url string) (*Verdict, error) {
+	v, err := verdicts.Load(s, url)
+	if err != nil {
+		return nil, fmt.Errorf("could not get verdict from db: %w", err)
+	}
+	return v, nil
+}
+
+func (s *Store) SaveVerdict(v *Verdict) error {
+	return verdicts.Save(s, v.URL, v)
+}
+
+// Verdicts returns all verdicts, by url.
+func (s *Store) Verdicts() ([]*Verdict, error) {
+	all, err := verdicts.All(s)
+	if err != nil {
+		return nil, fmt.Errorf("could not list verdicts: %w", err)
+	}
+	return all, nil
+}
+
+// DeleteVerdicts deletes the verdicts which match and returns their number.
+func (s *Store) DeleteVerdicts(match func(v *Verdict) bool) (int, error) {
+	n := 0
+	err := s.Update(func(tx *Tx) error {
+		matched := make([]string, 0)
+		err := verdicts.Each(tx, func(url string, v *Verdict) error {
+			if match(v) {
+				matched = append(matched, url)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, url := range matched {
+			err = verdicts.Delete(tx, url)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(matched)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not delete verdicts: %w", err)
+	}
+	return n, nil
+}
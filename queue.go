@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"newsbots/pkg/queue"
+	"newsbots/pkg/store"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runQueue runs the 'queue' subcommands.
+func runQueue(db *store.Store, c clients, binaryPath string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect a queue subcommand: 'list', 'retry', 'drop' or 'drain'")
+	}
+
+	switch args[0] {
+	case "list":
+		return printQueue(db)
+	case "retry", "drop":
+		if len(args) < 2 {
+			return fmt.Errorf("expect an item id or url: queue %s <id>", args[0])
+		}
+		for _, id := range args[1:] {
+			var err error
+			if args[0] == "retry" {
+				err = queue.Retry(db, id)
+			} else {
+				err = queue.Drop(db, id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "drain":
+		return drainQueue(context.Background(), db, c, binaryPath, nil)
+	default:
+		return fmt.Errorf("no valid queue subcommand %q. Expect 'list', 'retry', 'drop' or 'drain'", args[0])
+	}
+}
+
+// printQueue prints the waiting and the dead-lettered items.
+func printQueue(db *store.Store) error {
+	pending, err := queue.Pending(db)
+	if err != nil {
+		return err
+	}
+	dead, err := queue.Dead(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tATTEMPTS\tNEXT ATTEMPT\tACCOUNT\tTITLE\tLAST ERROR")
+	for _, item := range pending {
+		fmt.Fprintf(w, "%s\tpending\t%d\t%s\t%s\t%s\t%s\n", item.ID, item.Attempts,
+			item.NextAttempt.Format(time.RFC3339), item.Account, item.Title, item.LastError)
+	}
+	for _, item := range dead {
+		fmt.Fprintf(w, "%s\tdead\t%d\t-\t%s\t%s\t%s\n", item.ID, item.Attempts,
+			item.Account, item.Title, item.LastError)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"newsbots/pkg/report"
+	"newsbots/pkg/store"
+	"os"
+	"path"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// finishReport saves the report to the db and prints it. If reportPath is
+// given, the report is also written there as JSON or Markdown, depending on
+// the file extension.
+func finishReport(db *store.Store, rep *report.Report, reportPath string) error {
+	err := rep.Save(db)
+	if err != nil {
+		return fmt.Errorf("could not save report: %w", err)
+	}
+
+	err = rep.WriteTable(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("could not print report: %w", err)
+	}
+
+	if reportPath == "" {
+		return nil
+	}
+	ext := path.Ext(reportPath)
+	if ext != ".md" && ext != ".json" {
+		return fmt.Errorf("unknown report format %q, expect .json or .md", ext)
+	}
+	reportFile, err := os.Create(reportPath)
+	if err != nil {
+		return fmt.Errorf("could not create report file: %w", err)
+	}
+	defer reportFile.Close()
+
+	if ext == ".md" {
+		err = rep.WriteMarkdown(reportFile)
+	} else {
+		err = rep.WriteJSON(reportFile)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write report file: %w", err)
+	}
+	return nil
+}
+
+// printRuns lists the latest run reports. With a run ID as argument, the
+// full report of that run is printed.
+func printRuns(db *store.Store, args []string) error {
+	limit := 20
+	runID := ""
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			limit = n
+		} else {
+			runID = args[0]
+			limit = 1000
+		}
+	}
+
+	reports, err := report.List(db, limit)
+	if err != nil {
+		return err
+	}
+
+	if runID != "" {
+		for _, rep := range reports {
+			if rep.RunID == runID {
+				return rep.WriteTable(os.Stdout)
+			}
+		}
+		return fmt.Errorf("run %q not found", runID)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tCOMMAND\tSTART\tDURATION\tCANDIDATES\tPOSTED\tERRORS\tLLM CALLS")
+	for _, rep := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n",
+			rep.RunID, rep.Command, rep.Start.Format(time.RFC3339), rep.Duration(),
+			rep.Count("fetch"), rep.Count("publish"), rep.ErrorCount(), rep.LLMCalls)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"newsbots/pkg/posts/rss"
+	"newsbots/pkg/store"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type feedNextRun struct {
+	url       string
+	pollEvery string
+	lastFetch time.Time
+	nextRun   time.Time
+}
+
+// printNextRuns prints when each feed is due, the next one first.
+func printNextRuns(db *store.Store, binaryPath string) error {
+	feedConfigs, err := loadFeeds(db, binaryPath)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nextRuns := make([]feedNextRun, 0, len(feedConfigs))
+	for _, feedConfig := range feedConfigs {
+		if feedConfig.Disabled {
+			continue
+		}
+		pollEvery, err := feedConfig.pollInterval()
+		if err != nil {
+			return fmt.Errorf("could not parse poll_every of %q: %w", feedConfig.URL, err)
+		}
+		lastFetch, err := db.LastFetch(feedConfig.URL)
+		if err != nil {
+			return fmt.Errorf("could not LastFetch for %q: %w", feedConfig.URL, err)
+		}
+
+		n := feedNextRun{
+			url:       feedConfig.URL,
+			pollEvery: feedConfig.PollEvery,
+			lastFetch: lastFetch,
+			nextRun:   rss.NextRun(feedConfig.URL, pollEvery, lastFetch, now),
+		}
+		if n.pollEvery == "" {
+			n.pollEvery = "every run"
+		}
+		nextRuns = append(nextRuns, n)
+	}
+
+	sort.SliceStable(nextRuns, func(i, j int) bool {
+		return nextRuns[i].nextRun.Before(nextRuns[j].nextRun)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tPOLL EVERY\tLAST FETCH\tNEXT RUN")
+	for _, n := range nextRuns {
+		lastFetch := "never"
+		if !n.lastFetch.IsZero() {
+			lastFetch = n.lastFetch.Format(time.RFC3339)
+		}
+		nextRun := n.nextRun.Format(time.RFC3339)
+		if !n.nextRun.After(now) {
+			nextRun = "due"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n.url, n.pollEvery, lastFetch, nextRun)
+	}
+	return w.Flush()
+}
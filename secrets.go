@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"newsbots/pkg/aiapipro"
+	"newsbots/pkg/posts"
+	"newsbots/pkg/secrets"
+	"newsbots/pkg/store"
+	"os"
+	"path"
+	"strings"
+)
+
+// requiredSecrets are the secrets each command needs. The binary refuses to
+// start if one is missing. Commands not listed need none.
+var requiredSecrets = map[string][]string{
+	"rss":      {"PROMPTBETTER_TOKEN", "ACCOUNTS_KEY"},
+	"serve":    {"PROMPTBETTER_TOKEN", "ACCOUNTS_KEY"},
+	"moderate": {"ACCOUNTS_KEY"},
+	"upvote":   {"ACCOUNTS_KEY"},
+	"accounts": {"ACCOUNTS_KEY"},
+	"queue":    {"ACCOUNTS_KEY"},
+}
+
+// clients are the API clients with their credentials.
+type clients struct {
+	lemmy *aiapipro.Client
+	llm   *posts.PromptBetter
+}
+
+// secretsProvider reads secrets from the environment (or NAME_FILE), the
+// SECRETS_DIR directory (default /run/secrets) and, if KEYSTORE_KEY is set,
+// the encrypted keystore next to the binary.
+func secretsProvider(binaryPath string) (secrets.Provider, error) {
+	secretsDir := os.Getenv("SECRETS_DIR")
+	if secretsDir == "" {
+		secretsDir = "/run/secrets"
+	}
+	chain := secrets.Chain{secrets.Env{}, secrets.Dir{Path: secretsDir}}
+
+	if os.Getenv("KEYSTORE_KEY") == "" {
+		return chain, nil
+	}
+	keystore, err := openKeystore(binaryPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(chain, keystore), nil
+}
+
+func openKeystore(binaryPath string) (*secrets.Keystore, error) {
+	key, err := secrets.ParseKey(os.Getenv("KEYSTORE_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse KEYSTORE_KEY: %w", err)
+	}
+	keystore, err := secrets.OpenKeystore(path.Join(binaryPath, "secrets.enc"), key)
+	if err != nil {
+		return nil, fmt.Errorf("could not OpenKeystore: %w", err)
+	}
+	return keystore, nil
+}
+
+// requireSecrets returns the secrets the command needs. PASSWORD_SUFFIX is
+// optional, it is only needed to migrate the accounts from before the
+// account store.
+func requireSecrets(provider secrets.Provider, command string) (map[string]string, error) {
+	values, err := secrets.Require(provider, requiredSecrets[command]...)
+	if err != nil {
+		return nil, err
+	}
+	passwordSuffix, err := provider.Get("PASSWORD_SUFFIX")
+	if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		return nil, err
+	}
+	values["PASSWORD_SUFFIX"] = passwordSuffix
+	return values, nil
+}
+
+// newClients creates the API clients with the secrets.
+func newClients(db *store.Store, values map[string]string) (clients, error) {
+	var accounts *aiapipro.Accounts
+	if values["ACCOUNTS_KEY"] != "" {
+		key, err := secrets.ParseKey(values["ACCOUNTS_KEY"])
+		if err != nil {
+			return clients{}, fmt.Errorf("could not parse ACCOUNTS_KEY: %w", err)
+		}
+		aead, err := secrets.NewAEAD(key)
+		if err != nil {
+			return clients{}, err
+		}
+		accounts = aiapipro.NewAccounts(db, aead, values["PASSWORD_SUFFIX"])
+	}
+	c := clients{
+		lemmy: aiapipro.NewClient(accounts),
+		llm:   posts.NewPromptBetter(values["PROMPTBETTER_TOKEN"]),
+	}
+	// Point the bots to a stand-in, like a lemmytest server
+	if lemmyURL := os.Getenv("LEMMY_URL"); lemmyURL != "" {
+		c.lemmy.BaseURL = lemmyURL
+	}
+	if llmURL := os.Getenv("PROMPTBETTER_URL"); llmURL != "" {
+		c.llm.BaseURL = llmURL
+	}
+	return c, nil
+}
+
+// runSecrets manages the encrypted keystore. New values are read from stdin,
+// so they do not end up in the shell history.
+func runSecrets(binaryPath string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect a secrets subcommand: 'list', 'set' or 'delete'")
+	}
+	if os.Getenv("KEYSTORE_KEY") == "" {
+		return fmt.Errorf("KEYSTORE_KEY is not set")
+	}
+	keystore, err := openKeystore(binaryPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for _, name := range keystore.Names() {
+			fmt.Println(name)
+		}
+		return nil
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf("expect a secret name: secrets set <name>")
+		}
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		value = strings.TrimRight(value, "\r\n")
+		if value == "" && err != nil {
+			return fmt.Errorf("could not read secret from stdin: %w", err)
+		}
+		if value == "" {
+			return fmt.Errorf("empty secret on stdin")
+		}
+		keystore.Set(args[1], value)
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("expect a secret name: secrets delete <name>")
+		}
+		keystore.Delete(args[1])
+	default:
+		return fmt.Errorf("no valid secrets subcommand %q. Expect 'list', 'set' or 'delete'", args[0])
+	}
+	return keystore.Save()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"newsbots/pkg/aiapipro"
+	"newsbots/pkg/metrics"
+	"newsbots/pkg/report"
+	"newsbots/pkg/store"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type ServeConfig struct {
+	RSSEvery      string `json:"rss_every"`
+	ModerateEvery string `json:"moderate_every"`
+	SitemapEvery  string `json:"sitemap_every"`
+	// QueueEvery is how often the outbound queue is drained
+	QueueEvery  string `json:"queue_every"`
+	SitemapPath string `json:"sitemap_path"`
+	// Jitter is the maximum random delay added to every interval
+	Jitter string `json:"jitter"`
+	// MetricsAddr is the listen address of the /metrics endpoint. Empty
+	// disables it.
+	MetricsAddr string `json:"metrics_addr"`
+	// PostsFullEvery is how often the full post list of the site is
+	// loaded. In between the jobs only fetch the new posts.
+	PostsFullEvery string `json:"posts_full_every"`
+}
+
+var defaultServeConfig = ServeConfig{
+	RSSEvery:       "15m",
+	ModerateEvery:  "30m",
+	SitemapEvery:   "1h",
+	QueueEvery:     "1m",
+	SitemapPath:    "sitemap.xml",
+	Jitter:         "2m",
+	MetricsAddr:    ":2112",
+	PostsFullEvery: "24h",
+}
+
+type serveJob struct {
+	name  string
+	every time.Duration
+	run   func(ctx context.Context, runID string) error
+}
+
+// serve keeps running the rss, moderate, sitemap and queue jobs on their own
+// intervals until SIGTERM or SIGINT. Jobs never run at the same time, so
+// only one of them uses the db at once. Every job run logs with its own
+// run ID on top of the baseLogger.
+func serve(db *store.Store, c clients, binaryPath string, baseLogger *slog.Logger) error {
+	config := defaultServeConfig
+	configJSON, err := os.ReadFile(path.Join(binaryPath, "serve.json"))
+	if err == nil {
+		err = json.Unmarshal(configJSON, &config)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal serve config: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not open 'serve.json': %w", err)
+	}
+
+	jitter, err := time.ParseDuration(config.Jitter)
+	if err != nil {
+		return fmt.Errorf("could not parse jitter: %w", err)
+	}
+
+	postsFullEvery, err := time.ParseDuration(config.PostsFullEvery)
+	if err != nil {
+		return fmt.Errorf("could not parse posts_full_every: %w", err)
+	}
+	site := &sitePosts{db: db, lemmy: c.lemmy, fullEvery: postsFullEvery}
+
+	jobs := make([]serveJob, 0, 4)
+	for _, j := range []struct {
+		name  string
+		every string
+		run   func(ctx context.Context, runID string) error
+	}{
+		{"rss", config.RSSEvery, func(ctx context.Context, runID string) error {
+			allCurrentPosts, err := site.get()
+			if err != nil {
+				return fmt.Errorf("could not get site posts: %w", err)
+			}
+			rep := report.New(runID, "rss")
+			err = runRSS(ctx, db, c, binaryPath, allCurrentPosts, rep)
+			rep.Finish()
+			if saveErr := rep.Save(db); saveErr != nil {
+				slog.Error("could not save report", "error", saveErr)
+			}
+			return err
+		}},
+		{"moderate", config.ModerateEvery, func(ctx context.Context, runID string) error {
+			allCurrentPosts, err := site.get()
+			if err != nil {
+				return fmt.Errorf("could not get site posts: %w", err)
+			}
+			removed, err := runModerate(ctx, db, c, binaryPath, allCurrentPosts)
+			site.remove(removed)
+			return err
+		}},
+		{"sitemap", config.SitemapEvery, func(ctx context.Context, runID string) error {
+			allCurrentPosts, err := site.get()
+			if err != nil {
+				return fmt.Errorf("could not get site posts: %w", err)
+			}
+			return runSitemap(allCurrentPosts, config.SitemapPath)
+		}},
+		{"queue", config.QueueEvery, func(ctx context.Context, runID string) error {
+			return drainQueue(ctx, db, c, binaryPath, nil)
+		}},
+	} {
+		if j.every == "" {
+			// Disabled
+			continue
+		}
+		every, err := time.ParseDuration(j.every)
+		if err != nil {
+			return fmt.Errorf("could not parse interval of %s: %w", j.name, err)
+		}
+		jobs = append(jobs, serveJob{name: j.name, every: every, run: j.run})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if config.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default.Handler())
+		metricsServer := &http.Server{Addr: config.MetricsAddr, Handler: mux}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("could not serve metrics", "error", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	// Only one job at a time works on the db
+	var jobMutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job serveJob) {
+			defer wg.Done()
+			// The first run starts right away, only delayed by the jitter
+			wait := time.Duration(0)
+			for {
+				if jitter > 0 {
+					wait += time.Duration(rand.Int63n(int64(jitter)))
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+
+				jobMutex.Lock()
+				if ctx.Err() == nil {
+					// Jobs never overlap, so every job run gets its own run ID
+					runID := newRunID()
+					slog.SetDefault(baseLogger.With("run_id", runID, "job", job.name))
+					slog.Info("run job")
+					start := time.Now()
+					if err := job.run(ctx, runID); err != nil {
+						slog.Error("could not run job", "error", err)
+					}
+					slog.Info("finished job", "duration", time.Since(start))
+					metrics.Observe("newsbots_job_duration_seconds", time.Since(start).Seconds(), "job", job.name)
+					metrics.Add("newsbots_job_runs_total", 1, "job", job.name)
+				}
+				jobMutex.Unlock()
+				wait = job.every
+			}
+		}(job)
+	}
+
+	slog.Info("serving", "jobs", len(jobs))
+	<-ctx.Done()
+	slog.Info("shutting down, waiting for running job")
+	wg.Wait()
+
+	return nil
+}
+
+// sitePosts keeps the post list of the site for the jobs of serve. The full
+// list is loaded once per fullEvery, in between only the new posts are
+// fetched. Jobs never overlap, so it needs no lock.
+type sitePosts struct {
+	db        *store.Store
+	lemmy     *aiapipro.Client
+	fullEvery time.Duration
+
+	posts  []aiapipro.Post
+	loaded time.Time
+}
+
+// get returns a copy of the post list, oldest first. The urls of new posts
+// are marked as posted, like loadCurrentPosts does.
+func (s *sitePosts) get() ([]aiapipro.Post, error) {
+	if s.posts == nil || time.Since(s.loaded) >= s.fullEvery {
+		allCurrentPosts, err := loadCurrentPosts(s.db, s.lemmy)
+		if err != nil {
+			return nil, err
+		}
+		s.posts = allCurrentPosts
+		s.loaded = time.Now()
+	} else {
+		newestID := 0
+		for _, p := range s.posts {
+			newestID = max(newestID, p.ID)
+		}
+		newPosts, err := s.lemmy.GetNewPosts(newestID)
+		if err != nil {
+			return nil, fmt.Errorf("could not GetNewPosts: %w", err)
+		}
+		urls := make([]string, 0, len(newPosts))
+		for _, p := range newPosts {
+			urls = append(urls, p.URL)
+		}
+		err = s.db.MarkSeen(urls...)
+		if err != nil {
+			return nil, fmt.Errorf("could not set new posts to db: %w", err)
+		}
+		s.posts = append(s.posts, newPosts...)
+	}
+	// The jobs sort the list in place
+	return append([]aiapipro.Post(nil), s.posts...), nil
+}
+
+// remove drops the posts removed by moderation from the list.
+func (s *sitePosts) remove(postIDs []int) {
+	if len(postIDs) == 0 {
+		return
+	}
+	removed := make(map[int]bool, len(postIDs))
+	for _, id := range postIDs {
+		removed[id] = true
+	}
+	kept := s.posts[:0]
+	for _, p := range s.posts {
+		if !removed[p.ID] {
+			kept = append(kept, p)
+		}
+	}
+	s.posts = kept
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"newsbots/pkg/posts"
+	"newsbots/pkg/store"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const verdictsSubcommands = "'list', 'invalidate [url...]', 'prune' or 'reset-seen'"
+
+// invalidateHelp is the help of 'verdicts invalidate --help', with the
+// procedure for a changed prompt.
+const invalidateHelp = `Usage: newsbots verdicts invalidate [url...]
+
+Deletes the negative verdicts of the LLM for the urls, or all verdicts
+without urls. The articles are checked again by the next rss run.
+
+After a change of the check-if-post-is-about-ai prompt on PromptBetter,
+invalidate by version instead:
+  1. Bump CheckArticleVersion in pkg/posts/promptbetter.go and deploy. From
+     then on the verdicts of the old version are ignored.
+  2. Run 'newsbots verdicts prune' to delete them.
+Invalidate all verdicts only to check everything again with the same prompt.
+`
+
+// runVerdicts runs the 'verdicts' subcommands.
+func runVerdicts(db *store.Store, c clients, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("expect a verdicts subcommand: %s", verdictsSubcommands)
+	}
+
+	switch args[0] {
+	case "list":
+		return printVerdicts(db)
+	case "invalidate":
+		if len(args) > 1 && (args[1] == "-h" || args[1] == "--help") {
+			fmt.Print(invalidateHelp)
+			return nil
+		}
+		// Without urls all verdicts
+		urls := make(map[string]bool, len(args)-1)
+		for _, url := range args[1:] {
+			urls[url] = true
+		}
+		n, err := db.DeleteVerdicts(func(v *store.Verdict) bool {
+			return len(urls) == 0 || urls[v.URL]
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Invalidated %d verdicts\n", n)
+		return nil
+	case "prune":
+		now := time.Now()
+		n, err := db.DeleteVerdicts(func(v *store.Verdict) bool {
+			return !v.Valid(now, posts.CheckArticleVersion)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pruned %d expired or outdated verdicts\n", n)
+		return nil
+	case "reset-seen":
+		return resetSeen(db, c)
+	default:
+		return fmt.Errorf("no valid verdicts subcommand %q. Expect %s", args[0], verdictsSubcommands)
+	}
+}
+
+// printVerdicts prints the negative verdicts of the LLM.
+func printVerdicts(db *store.Store) error {
+	verdicts, err := db.Verdicts()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tSTATE\tSCORE\tPROMPT\tCREATED\tEXPIRES\tREASON")
+	for _, v := range verdicts {
+		state := "valid"
+		if v.PromptVersion != posts.CheckArticleVersion {
+			state = "outdated"
+		} else if !v.Valid(now, posts.CheckArticleVersion) {
+			state = "expired"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", v.URL, state, v.Score, v.PromptVersion,
+			v.Created.Format(time.RFC3339), v.Expires.Format(time.RFC3339), v.Reason)
+	}
+	return w.Flush()
+}
+
+// resetSeen drops the seen urls which are not on the site. Before the
+// verdicts the LLM rejections were marked as seen, this lets the articles be
+// checked again. Posts removed by moderation are not on the site either, so
+// their urls are dropped too.
+func resetSeen(db *store.Store, c clients) error {
+	// Marks the posts on the site as seen, also the ones new since the
+	// last run
+	allCurrentPosts, err := loadCurrentPosts(db, c.lemmy)
+	if err != nil {
+		return err
+	}
+	if len(allCurrentPosts) == 0 {
+		return fmt.Errorf("got no posts from the site, keep the seen urls")
+	}
+	onSite := make(map[string]bool, len(allCurrentPosts))
+	for _, p := range allCurrentPosts {
+		onSite[p.URL] = true
+	}
+
+	n, err := db.DeleteSeen(func(url string) bool {
+		return !onSite[url]
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dropped %d seen urls which are not on the site\n", n)
+	return nil
+}